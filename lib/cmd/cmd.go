@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"golang.org/x/sys/unix"
+
 	"wharfr.at/wharfrat/lib/cmd/exec"
 	"wharfr.at/wharfrat/lib/cmd/internal"
 	"wharfr.at/wharfrat/lib/cmd/proxy"
@@ -13,8 +15,32 @@ import (
 	"wharfr.at/wharfrat/lib/cmd/wr"
 )
 
+// dropPrivileges gives up the privileges of a setuid or setgid binary, since
+// only wr-init needs them.
+func dropPrivileges() error {
+	if gid := os.Getgid(); os.Getegid() != gid {
+		if err := unix.Setregid(gid, gid); err != nil {
+			return err
+		}
+	}
+	if uid := os.Getuid(); os.Geteuid() != uid {
+		if err := unix.Setreuid(uid, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func Main() int {
 	name := filepath.Base(os.Args[0])
+
+	// In containers wharfrat is a hard link to the setuid wr-init binary
+	if name != "wr-init" {
+		if err := dropPrivileges(); err != nil {
+			log.Printf("ERROR: failed to drop privileges: %s", err)
+			return 1
+		}
+	}
 	if strings.HasPrefix(name, "wr-") {
 		switch name[3:] {
 		case "exec":
@@ -7,18 +7,24 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
 type Setup struct {
-	User   string   `short:"u" long:"user" value-name:"USER"`
-	Uid    string   `short:"U" long:"uid" value-name:"UID" default:"1000"`
-	Group  string   `short:"g" long:"group" value-name:"GROUP"`
-	Gid    string   `short:"G" long:"gid" value-name:"GID" default:"1000"`
-	Groups []string `short:"e" long:"extra-group" value-name:"GROUP"`
-	Create []string `long:"create-group" value-name:"NAME=ID"`
-	Name   string   `short:"n" long:"name" value-name:"NAME"`
-	MkHome bool     `short:"h" long:"mkhome"`
+	User     string   `short:"u" long:"user" value-name:"USER"`
+	Uid      string   `short:"U" long:"uid" value-name:"UID" default:"1000"`
+	Group    string   `short:"g" long:"group" value-name:"GROUP"`
+	Gid      string   `short:"G" long:"gid" value-name:"GID" default:"1000"`
+	Groups   []string `short:"e" long:"extra-group" value-name:"GROUP"`
+	Create   []string `long:"create-group" value-name:"NAME=ID"`
+	Name     string   `short:"n" long:"name" value-name:"NAME"`
+	MkHome   bool     `short:"h" long:"mkhome"`
+	Chown    []string `long:"chown" value-name:"PATH"`
+	Existing bool     `long:"existing"`
 }
 
 func (s *Setup) create_group(entry string) error {
@@ -182,7 +188,74 @@ func (opts *Setup) setup_user_shadow() error {
 	return cmd.Run()
 }
 
+func (opts *Setup) update_user_busybox() error {
+	// busybox has no usermod, but can add the user to groups
+	groups := opts.Groups
+	if opts.Group != "" {
+		groups = append([]string{opts.Group}, groups...)
+	}
+
+	for _, group := range groups {
+		log.Printf("busybox addgroup args: %#v", []string{opts.User, group})
+
+		cmd := exec.Command("/usr/sbin/addgroup", opts.User, group)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (opts *Setup) update_user_shadow() error {
+	args := []string{}
+
+	if opts.Group != "" {
+		args = append(args, "--gid", opts.Group)
+	}
+
+	if len(opts.Groups) > 0 {
+		args = append(args, "--append", "--groups", strings.Join(opts.Groups, ","))
+	}
+
+	if opts.Name != "" {
+		args = append(args, "--comment", opts.Name)
+	}
+
+	if len(args) == 0 {
+		return nil
+	}
+
+	args = append(args, opts.User)
+
+	log.Printf("usermod args: %#v", args)
+
+	cmd := exec.Command("/usr/sbin/usermod", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (opts *Setup) update_user() error {
+	if path, err := os.Readlink("/usr/sbin/adduser"); err == nil && strings.HasSuffix(path, "busybox") {
+		return opts.update_user_busybox()
+	} else {
+		return opts.update_user_shadow()
+	}
+}
+
 func (opts *Setup) setup_user() error {
+	if opts.Existing {
+		if _, err := user.Lookup(opts.User); err == nil {
+			log.Printf("user %s already exists, updating", opts.User)
+			return opts.update_user()
+		}
+	}
+
 	if path, err := os.Readlink("/usr/sbin/adduser"); err == nil && strings.HasSuffix(path, "busybox") {
 		return opts.setup_user_busybox()
 	} else {
@@ -190,6 +263,61 @@ func (opts *Setup) setup_user() error {
 	}
 }
 
+// chown gives the user ownership of path, which is expected to be a volume
+// mount point. Any parent directories inside the user's home directory that
+// are owned by root (because docker created them for the mount) are also
+// given to the user, as long as they are in the container's own filesystem,
+// so that directories mounted from the host (e.g. with mount-home) are left
+// alone.
+func (opts *Setup) chown(path string) error {
+	uid, err := strconv.Atoi(opts.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(opts.Gid)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chown(path, uid, gid); err != nil {
+		return err
+	}
+
+	usr, err := user.Lookup(opts.User)
+	if err != nil {
+		return err
+	}
+	home := filepath.Clean(usr.HomeDir)
+
+	root, err := os.Stat("/")
+	if err != nil {
+		return err
+	}
+	rootStat, ok := root.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	for dir := filepath.Dir(path); dir == home || strings.HasPrefix(dir, home+"/"); dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); !ok || stat.Uid != 0 || stat.Dev != rootStat.Dev {
+			break
+		}
+		log.Printf("chown parent: %s", dir)
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+		if dir == home {
+			break
+		}
+	}
+
+	return nil
+}
+
 func (s *Setup) Execute(args []string) error {
 	log.Printf("Setup Args: %#v, Opts: %#v", args, s)
 
@@ -211,5 +339,11 @@ func (s *Setup) Execute(args []string) error {
 		}
 	}
 
+	for _, path := range s.Chown {
+		if err := s.chown(path); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", path, err)
+		}
+	}
+
 	return nil
 }
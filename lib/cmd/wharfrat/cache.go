@@ -0,0 +1,267 @@
+package wharfrat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+	"sort"
+
+	"github.com/docker/docker/api/types/volume"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
+	"wharfr.at/wharfrat/lib/docker/label"
+)
+
+type Cache struct {
+	CacheLs    `command:"ls" description:"List cache volumes"`
+	CacheRm    `command:"rm" description:"Remove cache volumes"`
+	CachePrune `command:"prune" description:"Remove cache volumes no longer used by any crate"`
+}
+
+func (c *Cache) Usage() string {
+	return "[cache-OPTIONS]"
+}
+
+// sortCaches sorts cache volumes by project, crate and then path.
+func sortCaches(caches []*volume.Volume) {
+	sort.Slice(caches, func(i, j int) bool {
+		a, b := caches[i].Labels, caches[j].Labels
+		if a[label.Project] != b[label.Project] {
+			return a[label.Project] < b[label.Project]
+		}
+		if a[label.Crate] != b[label.Crate] {
+			return a[label.Crate] < b[label.Crate]
+		}
+		return a[label.Cache] < b[label.Cache]
+	})
+}
+
+type CacheLs struct{}
+
+func (cl *CacheLs) Execute(args []string) error {
+	log.Printf("CACHE LS opts: %#v, args: %s", cl, args)
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	caches, err := client.ListCaches()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("FOUND: %d", len(caches))
+
+	sortCaches(caches)
+
+	maxName, maxProject, maxCrate := 11, 14, 5
+	for _, cache := range caches {
+		maxName = max(maxName, len(cache.Name))
+		maxProject = max(maxProject, len(cache.Labels[label.Project]))
+		maxCrate = max(maxCrate, len(cache.Labels[label.Crate]))
+	}
+
+	fmt.Printf("\033[37;1m%-*s\033[0m | ", maxName, "Volume Name")
+	fmt.Printf("\033[37;1m%-*s\033[0m | ", maxProject, "Project File")
+	fmt.Printf("\033[37;1m%-*s\033[0m | ", maxCrate, "Crate")
+	fmt.Printf("\033[37;1m%s\033[0m\n", "Path")
+	fmt.Printf("%s-+-", dashes(maxName))
+	fmt.Printf("%s-+-", dashes(maxProject))
+	fmt.Printf("%s-+-", dashes(maxCrate))
+	fmt.Printf("%s\n", dashes(15))
+	for _, cache := range caches {
+		fmt.Printf("%-*s | ", maxName, cache.Name)
+		fmt.Printf("%-*s | ", maxProject, cache.Labels[label.Project])
+		fmt.Printf("%-*s | ", maxCrate, cache.Labels[label.Crate])
+		fmt.Printf("%s\n", cache.Labels[label.Cache])
+	}
+
+	return nil
+}
+
+type CacheRm struct {
+	All   bool   `short:"a" long:"all" description:"Remove all cache volumes"`
+	Crate string `short:"c" long:"crate" value-name:"NAME" description:"Remove the cache volumes of the named crate"`
+}
+
+func (cr *CacheRm) Usage() string {
+	return "[rm-OPTIONS] [volume-name...]"
+}
+
+func (cr *CacheRm) Execute(args []string) error {
+	log.Printf("CACHE RM opts: %#v, args: %s", cr, args)
+
+	if cr.All && (len(args) != 0 || cr.Crate != "") {
+		return fmt.Errorf("no name or crate allowed with --all")
+	}
+	if cr.Crate != "" && len(args) != 0 {
+		return fmt.Errorf("no name allowed with --crate")
+	}
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	names := map[string]bool{}
+	for _, name := range args {
+		names[name] = true
+	}
+
+	// With no names, remove the caches of the current crate
+	projectPath, crateName := "", ""
+	if !cr.All && len(args) == 0 {
+		crate, err := config.GetCrate(".", cr.Crate, client)
+		if err != nil {
+			return fmt.Errorf("config error: %w", err)
+		}
+		projectPath, crateName = crate.ProjectPath(), crate.Name()
+	}
+
+	caches, err := client.ListCaches()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("FOUND: %d", len(caches))
+
+	sortCaches(caches)
+
+	for _, cache := range caches {
+		switch {
+		case cr.All:
+		case len(names) > 0:
+			if !names[cache.Name] {
+				continue
+			}
+			delete(names, cache.Name)
+		default:
+			if cache.Labels[label.Project] != projectPath || cache.Labels[label.Crate] != crateName {
+				continue
+			}
+		}
+
+		if err := client.RemoveCache(cache.Name); err != nil {
+			fmt.Printf("Failed to remove %s: %s\n", cache.Name, err)
+		} else {
+			fmt.Printf("%s (%s) removed\n", cache.Name, cache.Labels[label.Cache])
+		}
+	}
+
+	for name := range names {
+		fmt.Printf("%s is not a wharfrat cache volume\n", name)
+	}
+
+	return nil
+}
+
+// cacheKey identifies a crate's cache independently of the volume name.
+func cacheKey(project, crate, path string) string {
+	return project + "\x00" + crate + "\x00" + path
+}
+
+// cachesInUse returns the cache volume names mounted by existing containers,
+// and the keys of the caches in the configuration they were created from. This
+// covers caches of crates from other branches, which the current configuration
+// doesn't know about.
+func cachesInUse(client *docker.Connection) (map[string]bool, error) {
+	containers, err := client.List()
+	if err != nil {
+		return nil, err
+	}
+
+	inUse := map[string]bool{}
+	for _, container := range containers {
+		for _, mount := range container.Mounts {
+			if mount.Name != "" {
+				inUse[mount.Name] = true
+			}
+		}
+
+		cfg := struct{ Caches []string }{}
+		if err := json.Unmarshal([]byte(container.Labels[label.Config]), &cfg); err != nil {
+			log.Printf("CACHE PRUNE: failed to parse config of %v: %s", container.Names, err)
+			continue
+		}
+		for _, path := range cfg.Caches {
+			path = config.Expand(path, os.Getenv)
+			inUse[cacheKey(container.Labels[label.Project], container.Labels[label.Crate], path)] = true
+		}
+	}
+
+	return inUse, nil
+}
+
+type CachePrune struct {
+	Yes bool `short:"y" long:"yes" description:"Actually remove cache volumes"`
+}
+
+func (cp *CachePrune) Execute(args []string) error {
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	caches, err := client.ListCaches()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("FOUND: %d", len(caches))
+
+	sortCaches(caches)
+
+	inUse, err := cachesInUse(client)
+	if err != nil {
+		return err
+	}
+
+	missing := []*volume.Volume{}
+
+	for _, cache := range caches {
+		projectFile := cache.Labels[label.Project]
+		crateName := cache.Labels[label.Crate]
+
+		if inUse[cache.Name] || inUse[cacheKey(projectFile, crateName, cache.Labels[label.Cache])] {
+			continue
+		}
+
+		crate, err := config.OpenCrate(projectFile, crateName, client)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
+			return fmt.Errorf("failed to lookup crate: %w", err)
+		}
+
+		if crate == nil || !slices.Contains(crate.Caches, cache.Labels[label.Cache]) {
+			missing = append(missing, cache)
+		}
+	}
+
+	log.Printf("MISSING: %d", len(missing))
+
+	for _, cache := range missing {
+		if cp.Yes {
+			if err := client.RemoveCache(cache.Name); err != nil {
+				fmt.Printf("Failed to remove %s: %s\n", cache.Name, err)
+			} else {
+				fmt.Printf("Removed %s (%s)\n", cache.Name, cache.Labels[label.Cache])
+			}
+		} else {
+			fmt.Printf("Would remove %s (%s)\n", cache.Name, cache.Labels[label.Cache])
+		}
+	}
+
+	if !cp.Yes && len(missing) > 0 {
+		fmt.Printf("\nRe-run with --yes to remove cache volumes\n")
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package wharfrat
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
+)
+
+type Config struct {
+	ConfigShow     `command:"show" description:"Show the resolved crate config and where each value came from"`
+	ConfigValidate `command:"validate" description:"Check project and local config for errors"`
+}
+
+func (c *Config) Usage() string {
+	return "[config-OPTIONS]"
+}
+
+type ConfigShow struct {
+	Crate string `short:"c" long:"crate" value-name:"NAME" description:"Name of crate to show"`
+	JSON  bool   `short:"j" long:"json" description:"Output JSON"`
+	TOML  bool   `short:"t" long:"toml" description:"Output annotated TOML (default)"`
+}
+
+type showSetting struct {
+	Key     string      `json:"key"`
+	Value   interface{} `json:"value"`
+	Origins []string    `json:"origins"`
+}
+
+type showSetup struct {
+	Origin string             `json:"origin"`
+	Setup  *config.LocalSetup `json:"setup"`
+}
+
+type showOutput struct {
+	Project  string        `json:"project"`
+	Crate    string        `json:"crate"`
+	Settings []showSetting `json:"settings"`
+	Setups   []showSetup   `json:"setups"`
+}
+
+func tomlValue(value interface{}) (string, error) {
+	buf, err := toml.Marshal(map[string]interface{}{"v": value})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(buf), "v = ")), nil
+}
+
+func showComment(origins []string) string {
+	if len(origins) == 0 {
+		return ""
+	}
+	return "  # " + strings.Join(origins, ", ")
+}
+
+func (cs *ConfigShow) showTOML(crate *config.Crate, settings []config.Setting, locals []*config.LocalSetup) error {
+	fmt.Printf("# crate %s from %s\n", crate.Name(), crate.ProjectPath())
+
+	tables := []string{}
+	entries := map[string][]config.Setting{}
+	for _, setting := range settings {
+		table := ""
+		if len(setting.Key) > 1 {
+			table = setting.Key[:len(setting.Key)-1].String()
+		}
+		if _, found := entries[table]; !found && table != "" {
+			tables = append(tables, table)
+		}
+		entries[table] = append(entries[table], setting)
+	}
+
+	for i, table := range append([]string{""}, tables...) {
+		if i > 0 {
+			fmt.Printf("\n[%s]\n", table)
+		}
+		for _, setting := range entries[table] {
+			value, err := tomlValue(setting.Value)
+			if err != nil {
+				return err
+			}
+			name := setting.Key[len(setting.Key)-1:].String()
+			fmt.Printf("%s = %s%s\n", name, value, showComment(setting.Origins))
+		}
+	}
+
+	for _, local := range locals {
+		buf, err := toml.Marshal(map[string][]config.LocalSetup{"setups": {*local}})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n# local setup from %s\n%s", local.Origin(), buf)
+	}
+
+	return nil
+}
+
+func (cs *ConfigShow) showJSON(crate *config.Crate, settings []config.Setting, locals []*config.LocalSetup) error {
+	out := showOutput{
+		Project:  crate.ProjectPath(),
+		Crate:    crate.Name(),
+		Settings: make([]showSetting, 0, len(settings)),
+		Setups:   make([]showSetup, 0, len(locals)),
+	}
+
+	for _, setting := range settings {
+		out.Settings = append(out.Settings, showSetting{
+			Key:     setting.Key.String(),
+			Value:   setting.Value,
+			Origins: setting.Origins,
+		})
+	}
+
+	for _, local := range locals {
+		out.Setups = append(out.Setups, showSetup{Origin: local.Origin(), Setup: local})
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(out)
+}
+
+func (cs *ConfigShow) Execute(args []string) error {
+	log.Printf("Args: %#v, Opts: %#v", args, cs)
+
+	if cs.JSON && cs.TOML {
+		return fmt.Errorf("--json and --toml are not compatible")
+	}
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	crate, err := config.GetCrate(".", cs.Crate, client)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	locals, err := config.Local().Setup(crate)
+	if err != nil {
+		return err
+	}
+
+	settings := crate.Settings(locals)
+
+	if cs.JSON {
+		return cs.showJSON(crate, settings, locals)
+	}
+
+	return cs.showTOML(crate, settings, locals)
+}
+
+type ConfigValidate struct{}
+
+func (cv *ConfigValidate) Execute(args []string) error {
+	log.Printf("Args: %#v, Opts: %#v", args, cv)
+
+	local := config.Local()
+	problems := local.Validate()
+	if local.Path() != "" {
+		fmt.Printf("Checked %s\n", local.Path())
+	}
+
+	path, projectProblems, err := config.ValidateProject(".")
+	if err != nil && err != config.NotFound {
+		return err
+	}
+	if path != "" {
+		fmt.Printf("Checked %s\n", path)
+	}
+	problems = append(problems, projectProblems...)
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s)", len(problems))
+	}
+
+	return nil
+}
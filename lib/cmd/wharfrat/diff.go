@@ -0,0 +1,146 @@
+package wharfrat
+
+import (
+	"fmt"
+	"log"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
+	"wharfr.at/wharfrat/lib/docker/label"
+	"wharfr.at/wharfrat/lib/version"
+)
+
+type Diff struct {
+	Crate string `short:"c" long:"crate" value-name:"NAME" description:"Name of crate to compare"`
+}
+
+func diffValue(value interface{}) string {
+	if value == nil {
+		return "<unset>"
+	}
+	str, err := tomlValue(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return str
+}
+
+func (d *Diff) Execute(args []string) error {
+	log.Printf("DIFF: opts: %#v, args: %v", d, args)
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	crate, err := config.GetCrate(".", d.Crate, client)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	container, err := client.GetContainer(crate.ContainerName())
+	if err != nil {
+		return err
+	}
+
+	if container == nil {
+		fmt.Printf("No container for crate %s\n", crate.Name())
+		return nil
+	}
+
+	stale := false
+
+	changes, err := crate.Diff(container.Config.Labels[label.Config])
+	if err != nil {
+		return err
+	}
+
+	if reason := crate.PendingImage(); reason != "" {
+		// The image isn't known until the image-cmd is run, so don't show
+		// it as changed
+		fmt.Printf("Image:   not known (image-cmd not run: %s)\n", reason)
+		kept := changes[:0]
+		for _, change := range changes {
+			if change.Key.String() != "image" {
+				kept = append(kept, change)
+			}
+		}
+		changes = kept
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("Config:  unchanged\n")
+	} else {
+		stale = true
+		fmt.Printf("Config:  %d setting(s) changed\n", len(changes))
+		for _, change := range changes {
+			switch {
+			case change.Old == nil:
+				fmt.Printf("  + %s = %s\n", change.Key, diffValue(change.New))
+			case change.New == nil:
+				fmt.Printf("  - %s = %s\n", change.Key, diffValue(change.Old))
+			default:
+				fmt.Printf("  ~ %s: %s -> %s\n", change.Key, diffValue(change.Old), diffValue(change.New))
+			}
+		}
+	}
+
+	oldCommit := container.Config.Labels[label.Commit]
+	if oldCommit == version.Commit() {
+		fmt.Printf("Commit:  unchanged (%s)\n", oldCommit)
+	} else {
+		stale = true
+		fmt.Printf("Commit:  %s -> %s\n", oldCommit, version.Commit())
+	}
+
+	missing, err := docker.GUIMountsMissing(container, crate)
+	switch {
+	case err != nil:
+		fmt.Printf("GUI:     not checked (%s)\n", err)
+	case len(missing) > 0:
+		stale = true
+		fmt.Printf("GUI:     %d socket(s) moved\n", len(missing))
+		for _, mount := range missing {
+			fmt.Printf("  + %s\n", mount)
+		}
+	case len(crate.GUI) > 0:
+		fmt.Printf("GUI:     unchanged\n")
+	}
+
+	// Read-only containers are created from an image of the set up container
+	containerImage := container.Image
+	if base := container.Config.Labels[label.BaseImage]; base != "" {
+		containerImage = base
+	}
+
+	if crate.Build != nil {
+		if _, err := client.BuiltImage(crate); err != nil {
+			return err
+		}
+	}
+
+	image, err := client.GetImage(crate.Image)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case image == nil:
+		stale = true
+		fmt.Printf("Image:   %s -> %s (not available locally)\n", containerImage, crate.Image)
+	case image.ID == containerImage:
+		fmt.Printf("Image:   unchanged (%s)\n", image.ID)
+	default:
+		stale = true
+		fmt.Printf("Image:   %s -> %s (%s)\n", containerImage, image.ID, crate.Image)
+	}
+
+	if stale {
+		fmt.Printf("\nThe container is stale, use 'wharfrat run --clean' to recreate it\n")
+	} else {
+		fmt.Printf("\nThe container is up to date\n")
+	}
+
+	return nil
+}
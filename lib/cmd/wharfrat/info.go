@@ -11,7 +11,8 @@ import (
 )
 
 type Info struct {
-	Crate string `short:"c" long:"crate" value-name:"NAME" description:"Name of crate to run"`
+	Crate        string `short:"c" long:"crate" value-name:"NAME" description:"Name of crate to run"`
+	CheckUpdates bool   `short:"u" long:"check-updates" description:"Check the registry for a newer image"`
 }
 
 func (i *Info) Execute(args []string) error {
@@ -64,14 +65,48 @@ func (i *Info) Execute(args []string) error {
 		status = container.State.Status
 	}
 
+	update := "not checked (use --check-updates)"
+	if crate.Build != nil {
+		update = "n/a (image is built locally)"
+	} else if reason := crate.PendingImage(); reason != "" {
+		update = fmt.Sprintf("n/a (%s)", reason)
+	} else if i.CheckUpdates {
+		available, err := client.ImageUpdate(crate.Image)
+		switch {
+		case err != nil:
+			update = fmt.Sprintf("unknown (%s)", err)
+		case available:
+			update = "available (use 'wharfrat pull' to get it)"
+		default:
+			update = "none"
+		}
+	}
+
+	stale := fmt.Sprintf("%v", cfg != crate.Json())
+	if reason := crate.PendingImage(); reason != "" {
+		stale = fmt.Sprintf("unknown (%s)", reason)
+	}
+
+	fmt.Printf("Runtime:          %s\n", client.RuntimeName())
+	fmt.Printf("Endpoint:         %s\n", client.Endpoint())
 	fmt.Printf("Project Folder:   %s\n", project)
 	fmt.Printf("Crate:            %s\n", crate.Name())
-	fmt.Printf("Image:            %s\n", crate.Image)
+	image := crate.Image
+	if crate.Build != nil {
+		image = "built from " + crate.BuildDir()
+	} else if reason := crate.PendingImage(); reason != "" {
+		image = fmt.Sprintf("from image-cmd (not run: %s)", reason)
+	}
+
+	fmt.Printf("Image:            %s\n", image)
+	fmt.Printf("Image Update:     %s\n", update)
+	fmt.Printf("Platform:         %s\n", crate.PlatformName())
 	fmt.Printf("Container Name:   %s\n", crate.ContainerName())
 	fmt.Printf("Container Branch: %s\n", branch)
 	fmt.Printf("Container State:  %s\n", status)
-	fmt.Printf("Container Stale:  %v\n", cfg != crate.Json())
+	fmt.Printf("Container Stale:  %s\n", stale)
 	fmt.Printf("Container IP:     %s\n", addr)
+	fmt.Printf("Resource Limits:  %s\n", crate.ResourceSummary())
 
 	return nil
 }
@@ -1,7 +1,10 @@
 package wharfrat
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -15,7 +18,8 @@ import (
 )
 
 type List struct {
-	JSON bool `short:"j" long:"json" description:"JSON output instead of table"`
+	JSON         bool `short:"j" long:"json" description:"JSON output instead of table"`
+	CheckUpdates bool `short:"u" long:"check-updates" description:"Check the registry for newer images"`
 }
 
 type triState int
@@ -34,12 +38,13 @@ type strState struct {
 }
 
 type listEntry struct {
-	name    string
-	project strState
-	crate   strState
-	image   string
-	state   string
-	branch  strState
+	name     string
+	project  strState
+	crate    strState
+	image    strState
+	platform string
+	state    string
+	branch   strState
 }
 
 type tree map[string]tree
@@ -123,9 +128,10 @@ func (l *List) Execute(args []string) error {
 	log.Printf("FOUND: %d", len(containers))
 
 	entries := []listEntry{}
-	maxName, maxProject, maxBranch, maxCrate, maxImage := 14, 14, 16, 5, 5
+	maxName, maxProject, maxBranch, maxCrate, maxImage, maxPlatform := 14, 14, 16, 5, 5, 8
 
 	projects := tree{}
+	updates := map[string]bool{}
 
 	for _, container := range containers {
 		projectFile := container.Labels[label.Project]
@@ -137,7 +143,7 @@ func (l *List) Execute(args []string) error {
 		name := strings.TrimPrefix(container.Names[0], "/")
 		project := filepath.Dir(projectFile)
 		crate, err := config.OpenCrate(projectFile, crateName, client)
-		if err != nil && !os.IsNotExist(err) && err != config.CrateNotFound {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
 			return fmt.Errorf("failed to lookup crate: %w", err)
 		}
 
@@ -161,7 +167,7 @@ func (l *List) Execute(args []string) error {
 			if vc.KnownFile(projectFile, branch) {
 				log.Printf("OpenVcCrate: %s %s %s", projectFile, branch, crateName)
 				crate, err = config.OpenVcCrate(projectFile, branch, crateName, client)
-				if err != nil && !os.IsNotExist(err) && err != config.CrateNotFound {
+				if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
 					return fmt.Errorf("failed to lookup crate: %w", err)
 				}
 				projectState = green
@@ -178,7 +184,11 @@ func (l *List) Execute(args []string) error {
 		crateState := green
 		if crate == nil {
 			crateState = red
-		} else if crate.Json() != cfg || version.Commit() != commit {
+		} else if version.Commit() != commit {
+			crateState = amber
+		} else if crate.PendingImage() == "" && crate.Json() != cfg {
+			// The image isn't known until the image-cmd is run, which isn't
+			// done for untrusted projects, so the config can't be compared
 			crateState = amber
 		}
 
@@ -194,13 +204,41 @@ func (l *List) Execute(args []string) error {
 			maxImage = len(container.Image)
 		}
 
+		// The platform is taken from the config the container was created
+		// with, rather than the current crate config
+		stored := &config.Crate{}
+		if err := json.Unmarshal([]byte(cfg), stored); err != nil {
+			log.Printf("Failed to decode container config: %s", err)
+		}
+		platform := stored.PlatformName()
+		if len(platform) > maxPlatform {
+			maxPlatform = len(platform)
+		}
+
+		// Images with a newer version in the registry are shown in amber
+		imageState := normal
+		if l.CheckUpdates && crate != nil && crate.Build == nil {
+			update, checked := updates[crate.Image]
+			if !checked {
+				update, err = client.ImageUpdate(crate.Image)
+				if err != nil {
+					log.Printf("Failed to check for update to %s: %s", crate.Image, err)
+				}
+				updates[crate.Image] = update
+			}
+			if update {
+				imageState = amber
+			}
+		}
+
 		entries = append(entries, listEntry{
-			name:    name,
-			project: strState{project, projectState},
-			crate:   strState{crateName, crateState},
-			image:   container.Image,
-			state:   container.State,
-			branch:  strState{branch, branchState},
+			name:     name,
+			project:  strState{project, projectState},
+			crate:    strState{crateName, crateState},
+			image:    strState{container.Image, imageState},
+			platform: platform,
+			state:    container.State,
+			branch:   strState{branch, branchState},
 		})
 	}
 
@@ -212,7 +250,9 @@ func (l *List) Execute(args []string) error {
 			fmt.Printf(" \"project\": \"%s\",", entry.project.str)
 			fmt.Printf(" \"branch\": \"%s\",", entry.branch.str)
 			fmt.Printf(" \"crate\": \"%s\",", entry.crate.str)
-			fmt.Printf(" \"image\": \"%s\",", entry.image)
+			fmt.Printf(" \"image\": \"%s\",", entry.image.str)
+			fmt.Printf(" \"update\": %v,", entry.image.state == amber)
+			fmt.Printf(" \"platform\": \"%s\",", entry.platform)
 			fmt.Printf(" \"state\": \"%s\"", entry.state)
 			fmt.Printf("}")
 			if i+1 < len(entries) {
@@ -245,12 +285,14 @@ func (l *List) Execute(args []string) error {
 		fmt.Printf("\033[37;1m%-*s\033[0m | ", maxBranch, "Container Branch")
 		fmt.Printf("\033[37;1m%-*s\033[0m | ", maxCrate, "Crate")
 		fmt.Printf("\033[37;1m%-*s\033[0m | ", maxImage, "Image")
+		fmt.Printf("\033[37;1m%-*s\033[0m | ", maxPlatform, "Platform")
 		fmt.Printf("\033[37;1m%s\033[0m\n", "Container State")
 		fmt.Printf("%s-+-", dashes(maxName))
 		fmt.Printf("%s-+-", dashes(maxProject))
 		fmt.Printf("%s-+-", dashes(maxBranch))
 		fmt.Printf("%s-+-", dashes(maxCrate))
 		fmt.Printf("%s-+-", dashes(maxImage))
+		fmt.Printf("%s-+-", dashes(maxPlatform))
 		fmt.Printf("%s\n", dashes(15))
 		for _, entry := range entries {
 			fmt.Printf("%-*s", maxName, entry.name)
@@ -261,7 +303,9 @@ func (l *List) Execute(args []string) error {
 			fmt.Printf("\033[0m | ")
 			fmt.Printf("%s%-*s", entry.crate.state.fmt(), maxCrate, entry.crate.str)
 			fmt.Printf("\033[0m | ")
-			fmt.Printf("%-*s", maxImage, entry.image)
+			fmt.Printf("%s%-*s", entry.image.state.fmt(), maxImage, entry.image.str)
+			fmt.Printf("\033[0m | ")
+			fmt.Printf("%-*s", maxPlatform, entry.platform)
 			fmt.Printf("\033[0m | ")
 			fmt.Printf("%s", entry.state)
 			fmt.Printf("\033[0m\n")
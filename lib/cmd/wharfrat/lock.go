@@ -0,0 +1,164 @@
+package wharfrat
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
+)
+
+type Lock struct {
+	Check  bool `long:"check" description:"Fail if the lock file is out of date, instead of updating it"`
+	Update bool `short:"u" long:"update" description:"Pin already locked images to the latest digests"`
+}
+
+func (l *Lock) Execute(args []string) error {
+	log.Printf("LOCK: opts: %#v, args: %v", l, args)
+
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	project, err := config.LocateProject(".")
+	if err != nil {
+		return fmt.Errorf("failed to parse project file: %w", err)
+	}
+
+	old := project.Lock()
+	lock := config.NewLock(project)
+
+	names := make([]string, 0, len(project.Crates))
+	for name := range project.Crates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Images that would need to be resolved, which is only done when not
+	// checking (or when checking for updates)
+	unlocked := map[string]bool{}
+
+	// Crates that can't be opened are skipped, but fail the check since their
+	// images can't be compared
+	failed := 0
+
+	for _, name := range names {
+		crate, err := config.OpenCrate(project.Path(), name, client)
+		if err == nil && crate.PendingImage() != "" {
+			// The image comes from an image-cmd, which isn't run without
+			// asking
+			err = fmt.Errorf("image-cmd not run: %s", crate.PendingImage())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %s\n", name, err)
+			failed++
+			continue
+		}
+		if crate.Build != nil {
+			continue
+		}
+
+		image := crate.FloatingImage()
+		if _, found := lock.Images[image]; found || unlocked[image] {
+			continue
+		}
+
+		if old != nil && old.Pinned(image) != "" && !l.Update {
+			lock.Images[image] = old.Pinned(image)
+			continue
+		}
+
+		if l.Check && !l.Update {
+			unlocked[image] = true
+			continue
+		}
+
+		pinned, err := client.ResolveDigest(image)
+		if err != nil {
+			return fmt.Errorf("failed to resolve digest for %s: %w", image, err)
+		}
+		if pinned == image {
+			// Already pinned in the config
+			continue
+		}
+		lock.Images[image] = pinned
+	}
+
+	if l.Check {
+		return checkLock(old, lock, unlocked, failed)
+	}
+
+	if lock.Equal(old) {
+		fmt.Printf("%s is up to date\n", lock.Path())
+		return nil
+	}
+
+	if err := lock.Save(); err != nil {
+		return fmt.Errorf("failed to save lock file: %w", err)
+	}
+
+	fmt.Printf("Saved %s (%d image(s))\n", lock.Path(), len(lock.Images))
+
+	return nil
+}
+
+// checkLock reports the differences between the existing lock file and the
+// lock that would be written, returning an error if there are any or if some
+// crates failed to open.
+func checkLock(old, lock *config.Lock, unlocked map[string]bool, failed int) error {
+	oldImages := map[string]string{}
+	if old != nil {
+		oldImages = old.Images
+	}
+
+	images := []string{}
+	for image := range lock.Images {
+		images = append(images, image)
+	}
+	for image := range unlocked {
+		images = append(images, image)
+	}
+	for image := range oldImages {
+		if _, found := lock.Images[image]; !found && !unlocked[image] {
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+
+	changes := 0
+	for _, image := range images {
+		oldPinned, locked := oldImages[image]
+		pinned, used := lock.Images[image]
+		switch {
+		case !locked:
+			fmt.Printf("%s: not locked\n", image)
+		case !used && !unlocked[image]:
+			fmt.Printf("%s: no longer used\n", image)
+		case oldPinned != pinned:
+			fmt.Printf("%s: %s -> %s\n", image, oldPinned, pinned)
+		default:
+			continue
+		}
+		changes++
+	}
+
+	if changes > 0 {
+		return fmt.Errorf("lock file is out of date, run 'wharfrat lock' to update it")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to check %d crate(s)", failed)
+	}
+
+	fmt.Printf("%s is up to date\n", lock.Path())
+
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"log"
 	"os"
 
-	"wharfr.at/wharfrat/lib/config"
 	"wharfr.at/wharfrat/lib/docker"
 
 	"github.com/docker/docker/registry"
@@ -17,6 +16,7 @@ type Login struct {
 	Args loginArgs `positional-args:"true"`
 	User string    `short:"u" long:"user" description:"Username"`
 	Pass string    `short:"p" long:"password" description:"Password"`
+	List bool      `short:"l" long:"list" description:"List registries with saved credentials"`
 }
 
 type loginArgs struct {
@@ -47,7 +47,46 @@ func getPass(prompt string) string {
 	return getInput(prompt)
 }
 
+// listCredentials shows the registries that have saved credentials, and
+// where they are stored.
+func listCredentials() error {
+	creds, err := docker.LoadCredentials()
+	if err != nil {
+		return err
+	}
+
+	list, err := creds.List()
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		fmt.Println("No saved credentials")
+		return nil
+	}
+
+	maxServer, maxUser := len("Registry"), len("Username")
+	for _, cred := range list {
+		maxServer = max(maxServer, len(cred.Server))
+		maxUser = max(maxUser, len(cred.Username))
+	}
+
+	fmt.Printf("%-*s  %-*s  %s\n", maxServer, "Registry", maxUser, "Username", "Stored In")
+	for _, cred := range list {
+		fmt.Printf("%-*s  %-*s  %s\n", maxServer, cred.Server, maxUser, cred.Username, cred.Store)
+	}
+
+	return nil
+}
+
 func (l *Login) Execute(args []string) error {
+	if l.List {
+		if l.Args.Server != "" || l.User != "" || l.Pass != "" {
+			return fmt.Errorf("--list can't be used with a server or credentials")
+		}
+		return listCredentials()
+	}
+
 	client, err := docker.Connect()
 	if err != nil {
 		return err
@@ -104,14 +143,12 @@ func (l *Login) Execute(args []string) error {
 		return err
 	}
 
-	auth, err := config.LoadAuth()
+	creds, err := docker.LoadCredentials()
 	if err != nil {
 		return err
 	}
 
-	if err := auth.Set(authConfig); err != nil {
-		return err
-	}
+	creds.Migrate()
 
-	return auth.Save()
+	return creds.Store(authConfig)
 }
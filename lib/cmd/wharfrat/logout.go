@@ -3,7 +3,7 @@ package wharfrat
 import (
 	"fmt"
 
-	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
 )
 
 type Logout struct {
@@ -15,15 +15,18 @@ type logoutArgs struct {
 }
 
 func (l *Logout) Execute(args []string) error {
-	auth, err := config.LoadAuth()
+	creds, err := docker.LoadCredentials()
 	if err != nil {
 		return err
 	}
 
-	if _, ok := auth[l.Args.Server]; ok {
-		auth.Clear(l.Args.Server)
+	removed, err := creds.Erase(l.Args.Server)
+	if err != nil {
+		return err
+	}
+
+	if removed {
 		fmt.Printf("Removed credentials for %s\n", l.Args.Server)
-		return auth.Save()
 	}
 
 	return nil
@@ -1,9 +1,10 @@
 package wharfrat
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
 	"path/filepath"
 	"strings"
 
@@ -41,7 +42,7 @@ func (p *Prune) Execute(args []string) error {
 		name := strings.TrimPrefix(container.Names[0], "/")
 		project := filepath.Dir(projectFile)
 		crate, err := config.OpenCrate(projectFile, crateName, client)
-		if err != nil && !os.IsNotExist(err) && err != config.CrateNotFound {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
 			return fmt.Errorf("failed to lookup crate: %w", err)
 		}
 
@@ -57,7 +58,7 @@ func (p *Prune) Execute(args []string) error {
 			if vc.KnownFile(projectFile, branch) {
 				log.Printf("OpenVcCrate: %s %s %s", projectFile, branch, crateName)
 				crate, err = config.OpenVcCrate(projectFile, branch, crateName, client)
-				if err != nil && !os.IsNotExist(err) && err != config.CrateNotFound {
+				if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
 					return fmt.Errorf("failed to lookup crate: %w", err)
 				}
 			}
@@ -0,0 +1,90 @@
+package wharfrat
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
+)
+
+type Pull struct {
+	Crate string `short:"c" long:"crate" value-name:"NAME" description:"Name of crate to pull the image for"`
+	All   bool   `short:"a" long:"all" description:"Pull the images for all crates in the project"`
+}
+
+func (p *Pull) Execute(args []string) error {
+	log.Printf("PULL: opts: %#v, args: %v", p, args)
+
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if p.All && p.Crate != "" {
+		return fmt.Errorf("--crate not allowed with --all")
+	}
+
+	client, err := docker.Connect()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !p.All {
+		crate, err := config.GetCrate(".", p.Crate, client)
+		if err != nil {
+			return fmt.Errorf("config error: %w", err)
+		}
+		if reason := crate.PendingImage(); reason != "" {
+			return fmt.Errorf("image-cmd not run: %s", reason)
+		}
+		return client.Pull(crate)
+	}
+
+	project, err := config.LocateProject(".")
+	if err != nil {
+		return fmt.Errorf("failed to parse project file: %w", err)
+	}
+
+	names := make([]string, 0, len(project.Crates))
+	for name := range project.Crates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pulled := map[string]bool{}
+	failed := 0
+	for _, name := range names {
+		crate, err := config.GetCrate(".", name, client)
+		if err != nil {
+			fmt.Printf("Skipping %s: config error: %s\n", name, err)
+			failed++
+			continue
+		}
+		if crate.Build != nil {
+			fmt.Printf("Skipping %s: image is built from %s\n", name, crate.BuildDir())
+			continue
+		}
+		if reason := crate.PendingImage(); reason != "" {
+			fmt.Printf("Skipping %s: image-cmd not run: %s\n", name, reason)
+			continue
+		}
+		if pulled[crate.Image] {
+			continue
+		}
+		pulled[crate.Image] = true
+
+		fmt.Printf("Pulling %s for %s\n", crate.Image, name)
+		if err := client.Pull(crate); err != nil {
+			fmt.Printf("Failed to pull %s: %s\n", crate.Image, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to pull images for %d crate(s)", failed)
+	}
+
+	return nil
+}
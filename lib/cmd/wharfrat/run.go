@@ -55,7 +55,7 @@ func (opts *Run) client(args []string) (int, error) {
 	}
 	defer c.Close()
 
-	crate, err := config.GetCrate(".", opts.Crate, c)
+	crate, err := config.GetTrustedCrate(".", opts.Crate, c)
 	if err != nil {
 		return 1, fmt.Errorf("config error: %w", err)
 	}
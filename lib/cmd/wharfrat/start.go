@@ -1,9 +1,10 @@
 package wharfrat
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"os"
 	"strings"
 
 	"wharfr.at/wharfrat/lib/config"
@@ -54,7 +55,7 @@ func (s *Start) Execute(args []string) error {
 		name := strings.TrimPrefix(container.Names[0], "/")
 
 		crate, err := config.OpenCrate(projectFile, crateName, client)
-		if err != nil && !os.IsNotExist(err) && err != config.CrateNotFound {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, config.CrateNotFound) {
 			return fmt.Errorf("failed to lookup crate: %w", err)
 		}
 
@@ -0,0 +1,71 @@
+package wharfrat
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+type Trust struct {
+	Show bool `short:"s" long:"show" description:"Show the host commands and mounts without approving them"`
+}
+
+func (t *Trust) Execute(args []string) error {
+	log.Printf("TRUST: opts: %#v, args: %v", t, args)
+
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	project, err := config.LocateProject(".")
+	if err != nil {
+		return fmt.Errorf("failed to parse project file: %w", err)
+	}
+
+	project.ShowHostAccess(os.Stdout)
+
+	if t.Show {
+		if !project.Trusted() {
+			fmt.Printf("\n%s is not trusted\n", project.Path())
+		}
+		return nil
+	}
+
+	if err := project.Trust(); err != nil {
+		return fmt.Errorf("failed to save trust: %w", err)
+	}
+
+	fmt.Printf("\nTrusted %s\n", project.Path())
+
+	return nil
+}
+
+type Untrust struct{}
+
+func (u *Untrust) Execute(args []string) error {
+	log.Printf("UNTRUST: opts: %#v, args: %v", u, args)
+
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	project, err := config.LocateProject(".")
+	if err != nil {
+		return fmt.Errorf("failed to parse project file: %w", err)
+	}
+
+	removed, err := config.Untrust(project.Path())
+	if err != nil {
+		return fmt.Errorf("failed to save trust: %w", err)
+	}
+
+	if removed {
+		fmt.Printf("Removed trust for %s\n", project.Path())
+	} else {
+		fmt.Printf("%s was not trusted\n", project.Path())
+	}
+
+	return nil
+}
@@ -6,23 +6,32 @@ import (
 	"log"
 
 	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
 
 	flags "github.com/jessevdk/go-flags"
 )
 
 type options struct {
-	Debug   bool `short:"d" long:"debug" description:"Show debug output"`
+	Debug   bool   `short:"d" long:"debug" description:"Show debug output"`
+	Context string `long:"context" description:"Docker context to use"`
+	Cache   `command:"cache" description:"Manage persistent cache volumes"`
+	Config  `command:"config" description:"Inspect and check configuration"`
+	Diff    `command:"diff" description:"Show how a container differs from its crate"`
 	Env     `command:"env" description:"Manage wharfrat environment"`
 	Info    `command:"info" description:"Show information about current crate"`
 	List    `command:"list" description:"List existing containers"`
+	Lock    `command:"lock" description:"Pin crate images to digests in the lock file" long-description:"Pin crate images to digests in the lock file. Images are resolved using the crates for the current branch, so images only used by branch overrides for other branches are not locked."`
 	Login   `command:"login" description:"Cache credentials for a registry"`
 	Logout  `command:"logout" description:"Drop credentials for a registry"`
 	Prune   `command:"prune" description:"Remove containers for non-existent crates"`
+	Pull    `command:"pull" description:"Pull the latest image for crates"`
 	Remove  `command:"remove" description:"Remove an existing container"`
 	Rm      Remove `command:"rm" description:"Remove an existing container"`
 	Run     `command:"run" description:"Run a command in a container"`
 	Start   `command:"start" description:"Start an existing container"`
 	Stop    `command:"stop" description:"Stop an existing container"`
+	Trust   `command:"trust" description:"Approve the host commands and mounts of a project"`
+	Untrust `command:"untrust" description:"Remove the approval of a project"`
 	Version `command:"version" description:"Show version of tool"`
 }
 
@@ -33,6 +42,7 @@ func Main() int {
 
 	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
 		config.Debug = opts.Debug
+		docker.Context = opts.Context
 		if !config.Debug {
 			log.SetOutput(io.Discard)
 		}
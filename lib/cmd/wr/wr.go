@@ -9,6 +9,7 @@ import (
 
 	"wharfr.at/wharfrat/lib/cmd/wharfrat"
 	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker"
 	"wharfr.at/wharfrat/lib/version"
 
 	flags "github.com/jessevdk/go-flags"
@@ -16,8 +17,9 @@ import (
 
 type options struct {
 	wharfrat.Run
-	Debug   bool `short:"d" long:"debug" description:"Show debug output"`
-	Version bool `long:"version" description:"Show version of tool"`
+	Debug   bool   `short:"d" long:"debug" description:"Show debug output"`
+	Context string `long:"context" description:"Docker context to use"`
+	Version bool   `long:"version" description:"Show version of tool"`
 }
 
 func fatal(msg string, args ...interface{}) int {
@@ -43,6 +45,7 @@ func Main() int {
 	}
 
 	config.Debug = opts.Debug
+	docker.Context = opts.Context
 	if !config.Debug {
 		log.SetOutput(io.Discard)
 	}
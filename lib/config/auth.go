@@ -1,16 +1,16 @@
 package config
 
 import (
-	"encoding/base64"
 	"encoding/json"
-	"log"
 	"os"
-
-	"github.com/docker/docker/api/types/registry"
+	"path/filepath"
 )
 
 const authFilename = "auth.json"
 
+// Auth is the credentials saved by older versions of wharfrat, mapping
+// registry to encoded auth config. Credentials are now kept in the docker
+// credential store, so this is only read to move them over.
 type Auth map[string]string
 
 func LoadAuth() (Auth, error) {
@@ -23,26 +23,30 @@ func LoadAuth() (Auth, error) {
 	return auth, nil
 }
 
-func (a Auth) Set(authConfig *registry.AuthConfig) error {
-	buf, err := json.Marshal(authConfig)
-	if err != nil {
-		return err
-	}
-	addr := authConfig.ServerAddress
-	a[addr] = base64.URLEncoding.EncodeToString(buf)
-	log.Printf("AUTH SET: Addr: %s, Encoded: %s\n", addr, a[addr])
-	return nil
+// AuthPath returns the path of the legacy credentials file.
+func AuthPath() string {
+	return filepath.Join(configDir().Path, authFilename)
 }
 
 func (a Auth) Clear(name string) {
 	delete(a, name)
 }
 
+// Save writes the remaining credentials, removing the file once there are
+// none left.
 func (a Auth) Save() error {
+	if len(a) == 0 {
+		if err := os.Remove(AuthPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
 	f, err := configDir().Create(authFilename)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	e := json.NewEncoder(f)
 	e.SetIndent("", "  ")
 	return e.Encode(a)
@@ -0,0 +1,479 @@
+package config
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+
+	"wharfr.at/wharfrat/lib/vc"
+)
+
+// Build describes how to build the image for a crate from a Dockerfile in the
+// project.
+type Build struct {
+	Context    string            `toml:"context"`
+	Dockerfile string            `toml:"dockerfile"`
+	Target     string            `toml:"target"`
+	Args       map[string]string `toml:"args"`
+}
+
+// buildRepository is the repository that built crate images are tagged into.
+const buildRepository = "wharfrat-build"
+
+// BuildDir returns the absolute path of the build context.
+func (c *Crate) BuildDir() string {
+	dir := filepath.Dir(c.ProjectPath())
+	if filepath.IsAbs(c.Build.Context) {
+		return c.Build.Context
+	}
+	return filepath.Join(dir, c.Build.Context)
+}
+
+// BuildDockerfile returns the path of the Dockerfile, relative to the build
+// context.
+func (c *Crate) BuildDockerfile() string {
+	if c.Build.Dockerfile == "" {
+		return "Dockerfile"
+	}
+	return filepath.Clean(c.Build.Dockerfile)
+}
+
+// BuildFiles returns the files in the build context that should be sent to the
+// docker daemon, relative to the context directory. Files excluded by a
+// .dockerignore file are skipped, except for the Dockerfile and .dockerignore
+// itself, which are always sent.
+func (c *Crate) BuildFiles() ([]string, error) {
+	dir := c.BuildDir()
+
+	ignore, err := loadDockerignore(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		excluded, err := c.buildExcludes(ignore, rel)
+		if err != nil {
+			return err
+		}
+		if excluded {
+			if entry.IsDir() && !ignore.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build context: %w", err)
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// buildExcludes returns true if the .dockerignore patterns exclude the file at
+// rel from the build context.
+func (c *Crate) buildExcludes(ignore *patternmatcher.PatternMatcher, rel string) (bool, error) {
+	if rel == c.BuildDockerfile() || rel == ".dockerignore" {
+		return false, nil
+	}
+	return ignore.MatchesOrParentMatches(filepath.ToSlash(rel))
+}
+
+// buildHash returns a hash of the files in the build context and the build
+// settings, which is used to tag the built image. Files are hashed the same way
+// as git hashes blobs, so that the working tree and a branch with the same
+// content give the same hash.
+func (c *Crate) buildHash() (string, error) {
+	var files []vc.TreeEntry
+	var err error
+	if c.project.vcBranch != "" {
+		files, err = c.branchBuildFiles()
+	} else {
+		files, err = c.treeBuildFiles()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "dockerfile=%s\x00target=%s\x00", filepath.ToSlash(c.BuildDockerfile()), c.Build.Target)
+	if c.Platform != "" {
+		fmt.Fprintf(h, "platform=%s\x00", c.Platform)
+	}
+
+	args := make([]string, 0, len(c.Build.Args))
+	for name := range c.Build.Args {
+		args = append(args, name)
+	}
+	sort.Strings(args)
+	for _, name := range args {
+		fmt.Fprintf(h, "arg=%s=%s\x00", name, c.Build.Args[name])
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	for _, file := range files {
+		fmt.Fprintf(h, "file=%s\x00mode=%s\x00object=%s\x00", file.Path, file.Mode, file.Object)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// buildFilesFilename holds the object names of build context files in the
+// working tree, so that files only need to be read again when they change.
+const buildFilesFilename = "build-files.json"
+
+// fileObject is the cached object name of a build context file, which is used
+// for as long as the size, mode and modification time of the file are the
+// same.
+type fileObject struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Mode    string    `json:"mode"`
+	Object  string    `json:"object"`
+}
+
+// treeBuildFiles returns the files of the build context in the working tree.
+func (c *Crate) treeBuildFiles() ([]vc.TreeEntry, error) {
+	names, err := c.BuildFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := map[string]map[string]fileObject{}
+	if err := load(buildFilesFilename, &cache); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to load build file cache: %s", err)
+	}
+
+	// Files changed in the last second could change again without their
+	// modification time changing, so they aren't cached
+	recent := time.Now().Add(-time.Second)
+
+	dir := c.BuildDir()
+	cached := cache[dir]
+	objects := map[string]fileObject{}
+	changed := false
+	files := []vc.TreeEntry{}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		mode := "100644"
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			mode = "120000"
+		case info.Mode().IsRegular():
+			if info.Mode()&0111 != 0 {
+				mode = "100755"
+			}
+		default:
+			// Directories aren't hashed, as git doesn't track them
+			continue
+		}
+
+		entry, found := cached[name]
+		if !found || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) || entry.Mode != mode {
+			var data []byte
+			if mode == "120000" {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return nil, err
+				}
+				data = []byte(target)
+			} else if data, err = os.ReadFile(path); err != nil {
+				return nil, err
+			}
+			entry = fileObject{Size: info.Size(), ModTime: info.ModTime(), Mode: mode, Object: blobHash(data)}
+			changed = true
+		}
+		if entry.ModTime.Before(recent) {
+			objects[name] = entry
+		} else {
+			changed = true
+		}
+
+		files = append(files, vc.TreeEntry{Mode: mode, Object: entry.Object, Path: filepath.ToSlash(name)})
+	}
+
+	if changed || len(objects) != len(cached) {
+		cache[dir] = objects
+		if err := save(buildFilesFilename, cache); err != nil {
+			log.Printf("Failed to save build file cache: %s", err)
+		}
+	}
+
+	return files, nil
+}
+
+// branchBuildFiles returns the files of the build context on the branch that
+// the project was read from.
+func (c *Crate) branchBuildFiles() ([]vc.TreeEntry, error) {
+	projectDir, context, err := c.branchContext()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := vc.BranchedTree(projectDir, context, c.project.vcBranch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build context on %s: %w", c.project.vcBranch, err)
+	}
+
+	ignore, err := c.branchDockerignore(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []vc.TreeEntry{}
+	for _, entry := range entries {
+		excluded, err := c.buildExcludes(ignore, filepath.FromSlash(entry.Path))
+		if err != nil {
+			return nil, err
+		}
+		if !excluded {
+			files = append(files, entry)
+		}
+	}
+
+	return files, nil
+}
+
+// branchContext returns the path of the build context relative to the project
+// directory, for reading it from the branch that the project was read from.
+func (c *Crate) branchContext() (string, string, error) {
+	projectDir := filepath.Dir(c.ProjectPath())
+	context, err := filepath.Rel(projectDir, c.BuildDir())
+	return projectDir, context, err
+}
+
+// branchDockerignore loads the .dockerignore file of the build context on the
+// branch that the project was read from, if entries has one.
+func (c *Crate) branchDockerignore(entries []vc.TreeEntry) (*patternmatcher.PatternMatcher, error) {
+	projectDir, context, err := c.branchContext()
+	if err != nil {
+		return nil, err
+	}
+
+	var patterns []string
+	for _, entry := range entries {
+		if entry.Path == ".dockerignore" {
+			data, err := vc.BranchedFileIn(projectDir, filepath.Join(context, ".dockerignore"), c.project.vcBranch)
+			if err != nil {
+				return nil, err
+			}
+			if patterns, err = ignorefile.ReadAll(strings.NewReader(data)); err != nil {
+				return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+			}
+		}
+	}
+
+	ignore, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore: %w", err)
+	}
+	return ignore, nil
+}
+
+// WriteBuildContext writes a tar archive of the build context to w. The files
+// come from the same place as the ones that the image tag is hashed from, so
+// crates read from a branch are built from that branch.
+func (c *Crate) WriteBuildContext(w io.Writer) error {
+	if c.project.vcBranch != "" {
+		return c.writeBranchContext(w)
+	}
+
+	files, err := c.BuildFiles()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("BUILD CONTEXT: %s files:%d", c.BuildDir(), len(files))
+
+	return writeContext(w, c.BuildDir(), files)
+}
+
+// writeBranchContext writes a tar archive of the build context on the branch
+// that the project was read from to w, leaving out the files excluded by its
+// .dockerignore.
+func (c *Crate) writeBranchContext(w io.Writer) error {
+	projectDir, context, err := c.branchContext()
+	if err != nil {
+		return err
+	}
+
+	entries, err := vc.BranchedTree(projectDir, context, c.project.vcBranch)
+	if err != nil {
+		return fmt.Errorf("failed to read build context on %s: %w", c.project.vcBranch, err)
+	}
+
+	ignore, err := c.branchDockerignore(entries)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("BUILD CONTEXT: %s on %s files:%d", context, c.project.vcBranch, len(entries))
+
+	r, archive := io.Pipe()
+	go func() {
+		archive.CloseWithError(vc.BranchedArchive(projectDir, context, c.project.vcBranch, archive))
+	}()
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read build context on %s: %w", c.project.vcBranch, err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		excluded, err := c.buildExcludes(ignore, filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/")))
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeContext writes a tar archive of the named files from dir to w.
+func writeContext(w io.Writer, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("failed to add %s to build context: %w", name, err)
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			if err := copyFile(tw, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// blobHash returns the object name that git gives a file with the content
+// data.
+func blobHash(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SetBuildImage sets the image to the tag that the crate's build will produce.
+// This walks the whole build context, so it is only done when the image is
+// needed, but files are only read when they have changed.
+func (c *Crate) SetBuildImage() error {
+	hash, err := c.buildHash()
+	if err != nil {
+		return fmt.Errorf("build: %w", err)
+	}
+	c.Image = fmt.Sprintf("%s:%s", c.BuildRepository(), hash)
+	c.setOrigin("image", "build")
+	log.Printf("Build Image: %s", c.Image)
+	return nil
+}
+
+// BuildRepository returns the repository that the crate's built images are
+// tagged into.
+func (c *Crate) BuildRepository() string {
+	return buildRepository + "/" + strings.ToLower(c.name)
+}
+
+func loadDockerignore(path string) (*patternmatcher.PatternMatcher, error) {
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var patterns []string
+	if err == nil {
+		defer f.Close()
+		if patterns, err = ignorefile.ReadAll(f); err != nil {
+			return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+		}
+	}
+
+	ignore, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore: %w", err)
+	}
+	return ignore, nil
+}
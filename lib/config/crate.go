@@ -14,6 +14,8 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/BurntSushi/toml"
+
 	"wharfr.at/wharfrat/lib/docker/label"
 	"wharfr.at/wharfrat/lib/output"
 	"wharfr.at/wharfrat/lib/vc"
@@ -28,8 +30,8 @@ type Replace map[string]string
 func (r Replace) Rewrite(cmd string, w io.Writer, mapping func(string) string) io.Writer {
 	out := w
 	for match, replace := range r {
-		match := os.Expand(match, mapping)
-		replace := os.Expand(replace, mapping)
+		match := Expand(match, mapping)
+		replace := Expand(replace, mapping)
 		log.Printf("REPLACE (%s): %s -> %s", cmd, match, replace)
 		out = output.NewRewriter(out, []byte(match), []byte(replace))
 	}
@@ -37,35 +39,62 @@ func (r Replace) Rewrite(cmd string, w io.Writer, mapping func(string) string) i
 }
 
 type Crate struct {
-	CapAdd       []string           `toml:"cap-add"`
-	CapDrop      []string           `toml:"cap-drop"`
-	CopyGroups   []string           `toml:"copy-groups"`
-	CmdReplace   map[string]Replace `toml:"cmd-replace"`
-	Env          map[string]string  `toml:"env"`
-	EnvBlacklist []string           `toml:"env-blacklist"`
-	EnvWhitelist []string           `toml:"env-whitelist"`
-	ExportBin    []string           `toml:"export-bin"`
-	Groups       []string           `toml:"groups"`
-	Hostname     string             `toml:"hostname"`
-	Image        string             `toml:"image"`
-	ImageCmd     string             `toml:"image-cmd"`
-	MountHome    bool               `toml:"mount-home"`
-	Network      string             `toml:"network"`
-	PathAppend   []string           `toml:"path-append"`
-	PathPrepend  []string           `toml:"path-prepend"`
-	Ports        []string           `toml:"ports"`
-	ProjectMount string             `toml:"project-mount"`
-	SetupPost    string             `toml:"setup-post"`
-	SetupPre     string             `toml:"setup-pre"`
-	SetupPrep    string             `toml:"setup-prep"`
-	Shell        string             `toml:"shell"`
-	Tarballs     map[string]string  `toml:"tarballs"`
-	Tmpfs        []string           `toml:"tmpfs"`
-	Volumes      []string           `toml:"volumes"`
-	WorkingDir   string             `toml:"working-dir"`
-	project      *Project           `toml:"-"`
-	name         string             `toml:"-"`
-	branch       string             `toml:"-"`
+	Branches     map[string]Crate    `toml:"branches" json:"-"`
+	Build        *Build              `toml:"build" json:",omitempty"`
+	Caches       []string            `toml:"caches" json:",omitempty"`
+	CapAdd       []string            `toml:"cap-add"`
+	CapDrop      []string            `toml:"cap-drop"`
+	CopyGroups   []string            `toml:"copy-groups"`
+	Cpus         Quantity            `toml:"cpus" json:",omitempty"`
+	Cpuset       string              `toml:"cpuset" json:",omitempty"`
+	CmdReplace   map[string]Replace  `toml:"cmd-replace"`
+	Devices      []string            `toml:"devices" json:",omitempty"`
+	DeviceRules  []string            `toml:"device-cgroup-rules" json:",omitempty"`
+	Env          map[string]string   `toml:"env"`
+	EnvBlacklist []string            `toml:"env-blacklist"`
+	EnvWhitelist []string            `toml:"env-whitelist"`
+	ExportBin    []string            `toml:"export-bin"`
+	Extends      string              `toml:"extends" json:"-"`
+	GPGAgent     bool                `toml:"gpg-agent" json:",omitempty"`
+	Groups       []string            `toml:"groups"`
+	GUI          []string            `toml:"gui" json:",omitempty"`
+	Hostname     string              `toml:"hostname"`
+	Image        string              `toml:"image"`
+	ImageCmd     string              `toml:"image-cmd"`
+	Memory       Quantity            `toml:"memory" json:",omitempty"`
+	MemorySwap   Quantity            `toml:"memory-swap" json:",omitempty"`
+	MountHome    bool                `toml:"mount-home"`
+	Network      string              `toml:"network"`
+	PathAppend   []string            `toml:"path-append"`
+	PathPrepend  []string            `toml:"path-prepend"`
+	PidsLimit    int64               `toml:"pids-limit" json:",omitempty"`
+	Platform     string              `toml:"platform" json:",omitempty"`
+	Ports        []string            `toml:"ports"`
+	Privileged   bool                `toml:"privileged" json:",omitempty"`
+	ProjectMount string              `toml:"project-mount"`
+	PullPolicy   string              `toml:"pull-policy" json:"-"`
+	ReadOnly     bool                `toml:"read-only" json:",omitempty"`
+	SecurityOpt  []string            `toml:"security-opt" json:",omitempty"`
+	SetupPost    string              `toml:"setup-post"`
+	SetupPre     string              `toml:"setup-pre"`
+	SetupPrep    string              `toml:"setup-prep"`
+	Shell        string              `toml:"shell"`
+	ShmSize      Quantity            `toml:"shm-size" json:",omitempty"`
+	SSHAgent     bool                `toml:"ssh-agent" json:",omitempty"`
+	Tarballs     map[string]string   `toml:"tarballs"`
+	Tmpfs        []string            `toml:"tmpfs"`
+	Ulimits      map[string]Quantity `toml:"ulimits" json:",omitempty"`
+	Volumes      []string            `toml:"volumes"`
+	WorkingDir   string              `toml:"working-dir"`
+	Seccomp      []string            `toml:"-" json:",omitempty"`
+	project      *Project            `toml:"-"`
+	name         string              `toml:"-"`
+	branch       string              `toml:"-"`
+	floating     string              `toml:"-"`
+	defined      map[string]bool     `toml:"-"`
+	origins      map[string][]string `toml:"-"`
+	raw          *Crate              `toml:"-"`
+	pendingCmd   string              `toml:"-"`
 }
 
 const CrateNotFound = notFound("Crate Not Found")
@@ -87,7 +116,21 @@ func LocateCrate(start string) (string, error) {
 	return string(bytes.TrimSpace(data)), nil
 }
 
+// GetCrate returns the crate for the project containing start, without asking
+// the user to trust the project. The image-cmd of an untrusted project isn't
+// run until CheckTrust is called.
 func GetCrate(start, name string, ls LabelSource) (*Crate, error) {
+	return getCrate(start, name, ls, false)
+}
+
+// GetTrustedCrate returns the crate for the project containing start, asking
+// the user to trust the project if needed. This is for commands that run the
+// crate.
+func GetTrustedCrate(start, name string, ls LabelSource) (*Crate, error) {
+	return getCrate(start, name, ls, true)
+}
+
+func getCrate(start, name string, ls LabelSource, ask bool) (*Crate, error) {
 	project, err := LocateProject(start)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse project file: %w", err)
@@ -116,7 +159,7 @@ func GetCrate(start, name string, ls LabelSource) (*Crate, error) {
 		log.Printf("Failed to get branch name: %s", err)
 	}
 
-	return openCrate(project, crateName, branch, ls)
+	return openCrate(project, crateName, branch, ls, ask)
 }
 
 func runImageCmd(command string, projectDir string) (string, error) {
@@ -140,37 +183,122 @@ func runImageCmd(command string, projectDir string) (string, error) {
 	return strings.TrimSpace(buf.String()), nil
 }
 
-func openCrate(project *Project, crateName, branch string, ls LabelSource) (*Crate, error) {
-	crate, ok := project.Crates[crateName]
-	if !ok {
-		return nil, CrateNotFound
-	}
-
-	if crate.ImageCmd != "" {
-		image, err := runImageCmd(crate.ImageCmd, filepath.Dir(project.path))
-		if err != nil {
-			return nil, fmt.Errorf("image-cmd failed: %s", err)
-		}
-		if image != "" {
-			crate.Image = image
+// openCrate returns the named crate from the project. If ask is true then the
+// user is asked to trust the project if needed, otherwise an untrusted
+// project's image-cmd isn't run until CheckTrust is called.
+func openCrate(project *Project, crateName, branch string, ls LabelSource, ask bool) (*Crate, error) {
+	trusted := project.Trusted()
+	if ask && !trusted {
+		if err := project.checkTrust(); err != nil {
+			return nil, err
 		}
+		trusted = true
 	}
 
-	if crate.Image == "" {
-		return nil, fmt.Errorf("image is a required parameter")
+	crate, err := project.resolve(crateName, branch, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	crate.project = project
 	crate.name = crateName
 	crate.branch = branch
 
+	raw := *crate
+	crate.raw = &raw
+	crate.interpolate()
+	crate.hashSeccompProfiles()
+
+	rules, err := Local().PolicyFor(project.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The policy is only enforced when a container is created or run (see
+	// CheckPolicy), so that other commands still work for crates that break
+	// it, but image-cmd runs on the host so it isn't run for them
+	problems := rules.check(crate)
+
+	if crate.Build != nil {
+		// The build replaces any image setting, since the image name depends
+		// on the content of the build context. The name is only worked out
+		// when the image is needed, since that reads the whole context.
+		crate.Image = ""
+	} else if crate.ImageCmd != "" && !trusted {
+		log.Printf("Not running image-cmd of untrusted project %s", project.path)
+		crate.pendingCmd = "project not trusted"
+	} else if crate.ImageCmd != "" && len(problems) > 0 {
+		log.Printf("Not running image-cmd of crate %s, which breaks the local policy", crateName)
+		crate.pendingCmd = "crate breaks the local policy"
+	} else if err := crate.setImage(rules); err != nil {
+		return nil, err
+	}
+
 	if err := crate.SetDefaults(ls); err != nil {
 		return nil, err
 	}
 
 	log.Printf("Crate: %s, Image: %s", crateName, crate.Image)
 
-	return &crate, nil
+	return crate, nil
+}
+
+// setImage runs the crate's image-cmd, if it has one, and pins the image.
+func (c *Crate) setImage(rules PolicyRules) error {
+	if c.ImageCmd != "" {
+		image, err := runImageCmd(c.ImageCmd, filepath.Dir(c.project.path))
+		if err != nil {
+			return fmt.Errorf("image-cmd failed: %s", err)
+		}
+		if image != "" {
+			c.Image = image
+			c.setOrigin("image", "image-cmd")
+		}
+		if problems := rules.checkImage(c); len(problems) > 0 {
+			return problems
+		}
+	}
+
+	if c.Image == "" {
+		return fmt.Errorf("image is a required parameter")
+	}
+
+	c.pin()
+
+	return nil
+}
+
+// CheckTrust makes sure that the crate's project has been approved before a
+// container is created from it, asking the user if it hasn't, and then runs
+// the image-cmd that was held back, unless the crate breaks the local policy.
+func (c *Crate) CheckTrust(ls LabelSource) error {
+	if err := c.project.checkTrust(); err != nil {
+		return err
+	}
+
+	if c.pendingCmd == "" {
+		return nil
+	}
+
+	rules, err := Local().PolicyFor(c.project.path)
+	if err != nil {
+		return err
+	}
+	if problems := rules.check(c); len(problems) > 0 {
+		return problems
+	}
+	c.pendingCmd = ""
+	if err := c.setImage(rules); err != nil {
+		return err
+	}
+
+	return c.SetDefaults(ls)
+}
+
+// PendingImage returns why the crate's image-cmd hasn't been run yet, so that
+// the image isn't known, or an empty string if it has been run.
+func (c *Crate) PendingImage() string {
+	return c.pendingCmd
 }
 
 func OpenCrate(projectPath, crateName string, ls LabelSource) (*Crate, error) {
@@ -192,7 +320,7 @@ func OpenCrate(projectPath, crateName string, ls LabelSource) (*Crate, error) {
 	if err != nil {
 		log.Printf("Failed to get branch name: %s", err)
 	}
-	return openCrate(project, crateName, branch, ls)
+	return openCrate(project, crateName, branch, ls, false)
 }
 
 func OpenVcCrate(projectPath, branch, crateName string, ls LabelSource) (*Crate, error) {
@@ -205,50 +333,95 @@ func OpenVcCrate(projectPath, branch, crateName string, ls LabelSource) (*Crate,
 		return nil, err
 	}
 	project.path = projectPath
-	return openCrate(project, crateName, branch, ls)
+	project.vcBranch = branch
+	fsys := branchFS{dir: filepath.Dir(projectPath), branch: branch}
+	if err := project.include(fsys); err != nil {
+		return nil, err
+	}
+	if err := project.loadLock(fsys); err != nil {
+		return nil, err
+	}
+	return openCrate(project, crateName, branch, ls, false)
 }
 
 func (c *Crate) SetDefaults(ls LabelSource) error {
-	if !c.project.meta.IsDefined("crates", c.name, "mount-home") {
+	if !c.defined["mount-home"] {
 		c.MountHome = true
+		c.setOrigin("mount-home", originDefault)
 	}
 
-	if !c.project.meta.IsDefined("crates", c.name, "hostname") {
+	if !c.defined["hostname"] {
 		c.Hostname = ""
 	}
 
-	if !c.project.meta.IsDefined("crates", c.name, "shell") {
+	if local := Local(); !c.defined["ssh-agent"] && local.SSHAgent {
+		c.SSHAgent = true
+		c.setOrigin("ssh-agent", local.Path())
+	}
+
+	if local := Local(); !c.defined["gpg-agent"] && local.GPGAgent {
+		c.GPGAgent = true
+		c.setOrigin("gpg-agent", local.Path())
+	}
+
+	if !c.defined["shell"] {
 		c.Shell = ""
 	}
 
-	labels, err := ls.ImageLabels(c.Image)
-	if err != nil {
-		return err
+	// Built images aren't known until they are needed
+	var labels map[string]string
+	if c.Image != "" {
+		l, err := ls.ImageLabels(c.Image)
+		if err != nil {
+			return err
+		}
+		labels = l
 	}
 
 	if c.Hostname == "" {
 		c.Hostname = "dev"
+		c.setOrigin("hostname", originDefault)
 	}
 
 	if c.Shell == "" {
 		// Initially we look at the image labels to see if there is a shell
 		// specified with the image
 		c.Shell = labels[label.Shell]
+		c.setOrigin("shell", "image label "+label.Shell)
 	}
 
 	if c.Shell == "" {
 		// First default is the user's current shell
 		c.Shell = os.Getenv("SHELL")
+		c.setOrigin("shell", "host environment $SHELL")
 	}
 
 	if c.Shell == "" {
 		// Final fallback is /bin/sh
 		c.Shell = "/bin/sh"
+		c.setOrigin("shell", originDefault)
 	}
 
 	return nil
 }
 
+// originDefault is the origin recorded for values that wharfrat fills in when
+// they are not set in any config file.
+const originDefault = "built-in default"
+
+func (c *Crate) setOrigin(key, origin string) {
+	if c.origins == nil {
+		c.origins = map[string][]string{}
+	}
+	c.origins[toml.Key{key}.String()] = []string{origin}
+}
+
+// Origins returns where the value for key was set. For tables, key is the
+// dotted path to a single entry (e.g. env.HOME).
+func (c *Crate) Origins(key toml.Key) []string {
+	return c.origins[key.String()]
+}
+
 func (c *Crate) ProjectPath() string {
 	return c.project.path
 }
@@ -283,10 +456,30 @@ func (c *Crate) ContainerName() string {
 	return "wr_" + hash
 }
 
+// CacheVolume returns the name of the volume used for the cache at path. The
+// name doesn't depend on the branch, so that caches are shared between all the
+// containers for a crate.
+func (c *Crate) CacheVolume(path string) string {
+	h := md5.New()
+	for _, part := range []string{c.project.path, c.name, path} {
+		if _, err := h.Write([]byte(part + "\x00")); err != nil {
+			panic("Failed to write cache details: " + err.Error())
+		}
+	}
+	usr, err := user.Current()
+	if err != nil {
+		panic("Failed to get user information: " + err.Error())
+	}
+	if _, err := h.Write([]byte(usr.Username)); err != nil {
+		panic("Failed to write username: " + err.Error())
+	}
+	return "wr_cache_" + hex.EncodeToString(h.Sum(nil))
+}
+
 func (c *Crate) Json() string {
 	b := &strings.Builder{}
 	e := json.NewEncoder(b)
-	if err := e.Encode(c); err != nil {
+	if err := e.Encode(c.configured()); err != nil {
 		panic("Failed to encode crate to JSON: " + err.Error())
 	}
 	return b.String()
@@ -295,7 +488,7 @@ func (c *Crate) Json() string {
 func (c *Crate) Hash() string {
 	h := md5.New()
 	e := json.NewEncoder(h)
-	if err := e.Encode(c); err != nil {
+	if err := e.Encode(c.configured()); err != nil {
 		panic("Failed to encode crate to JSON: " + err.Error())
 	}
 	return hex.EncodeToString(h.Sum(nil))
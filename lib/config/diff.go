@@ -0,0 +1,91 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Change is a single setting that differs between two crate configs. Old or
+// New is nil if the setting is not set in that config.
+type Change struct {
+	Key toml.Key
+	Old interface{}
+	New interface{}
+}
+
+// hiddenKeys returns the crate keys that are not stored in the crate JSON, and
+// so can't be compared against a container.
+func hiddenKeys() map[string]bool {
+	hidden := map[string]bool{}
+	t := reflect.TypeOf(Crate{})
+	for i, key := range crateKeys() {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == "-" {
+			hidden[key] = true
+		}
+	}
+	return hidden
+}
+
+func (c *Crate) settingValues() (map[string]Setting, []string) {
+	hidden := hiddenKeys()
+	values := map[string]Setting{}
+	order := []string{}
+	for _, setting := range c.Settings(nil) {
+		if hidden[setting.Key[0]] {
+			continue
+		}
+		name := setting.Key.String()
+		values[name] = setting
+		order = append(order, name)
+	}
+	return values, order
+}
+
+// Diff compares the crate with a crate config stored as JSON (e.g. in a
+// container label), returning the settings that differ. Tables are compared
+// entry by entry.
+func (c *Crate) Diff(oldJSON string) ([]Change, error) {
+	old := &Crate{}
+	if err := json.Unmarshal([]byte(oldJSON), old); err != nil {
+		return nil, fmt.Errorf("failed to decode container config: %w", err)
+	}
+
+	oldValues, oldOrder := old.settingValues()
+	newValues, newOrder := c.settingValues()
+
+	changes := []Change{}
+	for _, name := range newOrder {
+		setting := newValues[name]
+		change := Change{Key: setting.Key, New: setting.Value}
+		if oldSetting, found := oldValues[name]; found {
+			if reflect.DeepEqual(oldSetting.Value, setting.Value) {
+				continue
+			}
+			change.Old = oldSetting.Value
+		}
+		changes = append(changes, change)
+	}
+
+	for _, name := range oldOrder {
+		if _, found := newValues[name]; !found {
+			setting := oldValues[name]
+			changes = append(changes, Change{Key: setting.Key, Old: setting.Value})
+		}
+	}
+
+	// The profiles themselves aren't settings, so they are compared by hash
+	if !reflect.DeepEqual(old.Seccomp, c.Seccomp) {
+		changes = append(changes, Change{Key: toml.Key{"security-opt", "seccomp"}, Old: old.Seccomp, New: c.Seccomp})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Key.String() < changes[j].Key.String()
+	})
+
+	return changes, nil
+}
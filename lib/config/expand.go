@@ -0,0 +1,198 @@
+package config
+
+import (
+	"strings"
+)
+
+// Expand replaces $NAME, ${NAME} and ${NAME:-default} in s with values from
+// mapping. The default is used if the variable is empty, and may itself contain
+// variables. $$ is replaced by a literal $, and a $ that doesn't start a
+// variable reference is left as is.
+func Expand(s string, mapping func(string) string) string {
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 >= len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		switch next := s[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				b.WriteByte(s[i])
+				continue
+			}
+			b.WriteString(expandBraced(s[i+2:end], mapping))
+			i = end
+		case isNameByte(next, true):
+			end := i + 2
+			for end < len(s) && isNameByte(s[end], false) {
+				end++
+			}
+			b.WriteString(mapping(s[i+1 : end]))
+			i = end - 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandBraced expands the content of a ${...} reference.
+func expandBraced(ref string, mapping func(string) string) string {
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	value := mapping(name)
+	if value == "" && hasDefault {
+		return Expand(def, mapping)
+	}
+	return value
+}
+
+// closingBrace returns the index of the brace that closes a ${ reference whose
+// content starts at start, allowing for nested references in the default. If
+// there is no closing brace, -1 is returned.
+func closingBrace(s string, start int) int {
+	depth := 1
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameByte(c byte, first bool) bool {
+	switch {
+	case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	}
+	return false
+}
+
+func expandList(list []string, mapping func(string) string) []string {
+	if list == nil {
+		return nil
+	}
+	expanded := make([]string, 0, len(list))
+	for _, entry := range list {
+		expanded = append(expanded, Expand(entry, mapping))
+	}
+	return expanded
+}
+
+// interpolate expands variable references in the crate settings that refer to
+// paths, names or values. Scripts are left alone, since they are run by a shell
+// that does its own expansion, and cmd-replace is expanded when the command is
+// run so that it has access to WHARFRAT_ID.
+func (c *Crate) interpolate() {
+	mapping := c.Getenv
+
+	c.Hostname = Expand(c.Hostname, mapping)
+	c.Image = Expand(c.Image, mapping)
+	c.Network = Expand(c.Network, mapping)
+	c.ProjectMount = Expand(c.ProjectMount, mapping)
+	c.Shell = Expand(c.Shell, mapping)
+	c.WorkingDir = Expand(c.WorkingDir, mapping)
+
+	c.Caches = expandList(c.Caches, mapping)
+	c.Devices = expandList(c.Devices, mapping)
+	c.ExportBin = expandList(c.ExportBin, mapping)
+	c.PathAppend = expandList(c.PathAppend, mapping)
+	c.PathPrepend = expandList(c.PathPrepend, mapping)
+	c.Ports = expandList(c.Ports, mapping)
+	c.SecurityOpt = expandList(c.SecurityOpt, mapping)
+	c.Tmpfs = expandList(c.Tmpfs, mapping)
+	c.Volumes = expandList(c.Volumes, mapping)
+
+	if c.Env != nil {
+		env := make(map[string]string, len(c.Env))
+		for name, value := range c.Env {
+			env[name] = Expand(value, mapping)
+		}
+		c.Env = env
+	}
+
+	if c.Tarballs != nil {
+		tarballs := make(map[string]string, len(c.Tarballs))
+		for src, dst := range c.Tarballs {
+			tarballs[Expand(src, mapping)] = Expand(dst, mapping)
+		}
+		c.Tarballs = tarballs
+	}
+
+	if c.Build != nil {
+		build := *c.Build
+		build.Context = Expand(build.Context, mapping)
+		build.Dockerfile = Expand(build.Dockerfile, mapping)
+		build.Target = Expand(build.Target, mapping)
+		if build.Args != nil {
+			build.Args = make(map[string]string, len(c.Build.Args))
+			for name, value := range c.Build.Args {
+				build.Args[name] = Expand(value, mapping)
+			}
+		}
+		c.Build = &build
+	}
+}
+
+// configured returns the crate with the settings that interpolate expands put
+// back as they were written, so that the container config doesn't change with
+// the host environment. Values that were replaced after interpolation (e.g. by
+// image-cmd or the defaults) are kept.
+func (c *Crate) configured() *Crate {
+	if c.raw == nil {
+		return c
+	}
+
+	cfg := *c
+	raw := c.raw
+	restore := func(value *string, written string) {
+		if *value == Expand(written, c.Getenv) {
+			*value = written
+		}
+	}
+
+	restore(&cfg.Hostname, raw.Hostname)
+	restore(&cfg.Image, raw.Image)
+	if cfg.Build != nil {
+		// The image, and any shell from its labels, are only known once the
+		// build context has been hashed, and changes to those show up as a
+		// new image anyway
+		cfg.Image = raw.Image
+		if !c.defined["shell"] {
+			cfg.Shell = raw.Shell
+		}
+	}
+	restore(&cfg.Network, raw.Network)
+	restore(&cfg.ProjectMount, raw.ProjectMount)
+	restore(&cfg.Shell, raw.Shell)
+	restore(&cfg.WorkingDir, raw.WorkingDir)
+
+	cfg.Caches = raw.Caches
+	cfg.Devices = raw.Devices
+	cfg.ExportBin = raw.ExportBin
+	cfg.PathAppend = raw.PathAppend
+	cfg.PathPrepend = raw.PathPrepend
+	cfg.Ports = raw.Ports
+	cfg.SecurityOpt = raw.SecurityOpt
+	cfg.Tmpfs = raw.Tmpfs
+	cfg.Volumes = raw.Volumes
+	cfg.Env = raw.Env
+	cfg.Tarballs = raw.Tarballs
+	cfg.Build = raw.Build
+
+	return &cfg
+}
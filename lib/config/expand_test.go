@@ -0,0 +1,63 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{
+		"HOME":  "/home/me",
+		"USER":  "me",
+		"EMPTY": "",
+		"A_1":   "a1",
+	}
+	mapping := func(name string) string {
+		return vars[name]
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"$HOME/src", "/home/me/src"},
+		{"${HOME}src", "/home/mesrc"},
+		{"$USER.$USER", "me.me"},
+		{"$A_1x", ""},
+		{"${A_1}x", "a1x"},
+		{"$MISSING", ""},
+		{"${MISSING:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${USER:-default}", "me"},
+		{"${MISSING:-$HOME/cache}", "/home/me/cache"},
+		{"${MISSING:-${EMPTY:-${USER}}}", "me"},
+		{"$$HOME", "$HOME"},
+		{"cost: $5", "cost: $5"},
+		{"trailing $", "trailing $"},
+		{"${HOME", "${HOME"},
+		{"$-", "$-"},
+	}
+
+	for _, test := range tests {
+		if got := Expand(test.in, mapping); got != test.want {
+			t.Errorf("Expand(%q): got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestExpandList(t *testing.T) {
+	mapping := func(name string) string {
+		return "/" + name
+	}
+
+	if got := expandList(nil, mapping); got != nil {
+		t.Errorf("nil list: got %q, want nil", got)
+	}
+
+	got := expandList([]string{"$A:/a", "${B}:/b", "/c"}, mapping)
+	if want := []string{"/A:/a", "/B:/b", "/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
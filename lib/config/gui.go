@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+)
+
+// The GUI features, which decide what display and audio servers on the host
+// are made available to a crate.
+const (
+	GUIX11      = "x11"
+	GUIWayland  = "wayland"
+	GUIPulse    = "pulse"
+	GUIPipeWire = "pipewire"
+)
+
+func checkGUI(features []string) []string {
+	msgs := []string{}
+	for _, feature := range features {
+		switch feature {
+		case GUIX11, GUIWayland, GUIPulse, GUIPipeWire:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid gui '%s': expected x11, wayland, pulse or pipewire", feature))
+		}
+	}
+	return msgs
+}
+
+// UsesGUI returns true if the crate lists the GUI feature.
+func (c *Crate) UsesGUI(feature string) bool {
+	for _, f := range c.GUI {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+
+	"wharfr.at/wharfrat/lib/vc"
+)
+
+// defaultInclude is used to find extra project files when the project does
+// not specify an include list.
+const defaultInclude = ".wrproject.d/*.toml"
+
+// projectFS gives access to the files that make up a project. Paths are
+// relative to the project directory.
+type projectFS interface {
+	ReadFile(name string) (string, error)
+	ReadDir(name string) ([]string, error)
+}
+
+// dirFS reads project files from the working tree.
+type dirFS string
+
+func (d dirFS) ReadFile(name string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), name))
+	return string(data), err
+}
+
+func (d dirFS) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), name))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+// branchFS reads project files from a version control branch.
+type branchFS struct {
+	dir    string
+	branch string
+}
+
+func (b branchFS) ReadFile(name string) (string, error) {
+	if !vc.KnownFileIn(b.dir, name, b.branch) {
+		return "", fmt.Errorf("%s on %s: %w", name, b.branch, os.ErrNotExist)
+	}
+	return vc.BranchedFileIn(b.dir, name, b.branch)
+}
+
+func (b branchFS) ReadDir(name string) ([]string, error) {
+	return vc.BranchedDir(b.dir, name, b.branch)
+}
+
+// source records where a crate definition was loaded from.
+type source struct {
+	path string
+	meta toml.MetaData
+	data string
+}
+
+type includeFile struct {
+	Crates map[string]Crate
+}
+
+// glob returns the files matching pattern, which may only contain wildcards in
+// the final path element.
+func glob(fsys projectFS, pattern string) ([]string, error) {
+	dir, base := filepath.Split(pattern)
+	names, err := fsys.ReadDir(filepath.Clean(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	matches := []string{}
+	for _, name := range names {
+		ok, err := filepath.Match(base, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern '%s': %w", pattern, err)
+		}
+		if ok {
+			matches = append(matches, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// include loads the crates from the files listed in the project's include
+// setting (or the .wrproject.d directory if there is no include setting), and
+// adds them to the project.
+func (p *Project) include(fsys projectFS) error {
+	patterns := p.Include
+	if !p.meta.IsDefined("include") {
+		patterns = []string{defaultInclude}
+	}
+
+	for _, pattern := range patterns {
+		if filepath.IsAbs(pattern) {
+			return fmt.Errorf("include '%s' should be relative to the project", pattern)
+		}
+		names, err := glob(fsys, pattern)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 && p.meta.IsDefined("include") {
+			return fmt.Errorf("include '%s' did not match any files", pattern)
+		}
+		for _, name := range names {
+			if err := p.includeFile(fsys, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Project) includeFile(fsys projectFS, name string) error {
+	path := filepath.Join(filepath.Dir(p.path), name)
+
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var inc includeFile
+	md, err := toml.Decode(data, &inc)
+	if err != nil {
+		return Problems{ProblemFromError(path, err)}
+	}
+
+	log.Printf("Unknown config keys (%s): %s", path, md.Undecoded())
+	log.Printf("Include File: %s", path)
+
+	if p.Crates == nil {
+		p.Crates = map[string]Crate{}
+	}
+	if p.sources == nil {
+		p.sources = map[string]source{}
+	}
+
+	src := source{path: path, meta: md, data: data}
+	p.includes = append(p.includes, src)
+
+	for crateName, crate := range inc.Crates {
+		if _, found := p.Crates[crateName]; found {
+			return fmt.Errorf("crate %s defined in both %s and %s", crateName, p.SourcePath(crateName), path)
+		}
+		p.Crates[crateName] = crate
+		p.sources[crateName] = src
+	}
+
+	return nil
+}
+
+// SourcePath returns the path of the file that defined the named crate.
+func (p *Project) SourcePath(crateName string) string {
+	if src, found := p.sources[crateName]; found {
+		return src.path
+	}
+	return p.path
+}
+
+// mainSource returns the project file itself.
+func (p *Project) mainSource() source {
+	return source{path: p.path, meta: p.meta, data: p.data}
+}
+
+// crateSource returns the file that defined the named crate.
+func (p *Project) crateSource(crateName string) source {
+	if src, found := p.sources[crateName]; found {
+		return src
+	}
+	return p.mainSource()
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
@@ -19,17 +21,33 @@ type LocalSetup struct {
 	Env       map[string]string `toml:"env"`
 	project   *regexp.Regexp
 	crate     *regexp.Regexp
+	origin    string
 }
 
 type LocalConfig struct {
-	DockerURL string       `toml:"docker-url"`
-	AutoClean bool         `toml:"auto-clean"`
-	Setups    []LocalSetup `toml:"setups"`
-	path      string
+	Runtime    string       `toml:"runtime"`
+	DockerURL  string       `toml:"docker-url"`
+	PodmanURL  string       `toml:"podman-url"`
+	AutoClean  bool         `toml:"auto-clean"`
+	PullPolicy string       `toml:"pull-policy"`
+	SSHAgent   bool         `toml:"ssh-agent"`
+	GPGAgent   bool         `toml:"gpg-agent"`
+	Policy     Policy       `toml:"policy"`
+	Setups     []LocalSetup `toml:"setups"`
+	path       string
+	meta       toml.MetaData
+	data       string
+	err        error
 }
 
 const localName = "config.toml"
 
+// The container runtimes that wharfrat can use.
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
 var (
 	localConfig LocalConfig
 	localOnce   sync.Once
@@ -45,13 +63,27 @@ func loadLocal() {
 	}
 	defer f.Close()
 
-	md, err := toml.NewDecoder(f).Decode(&localConfig)
+	data, err := io.ReadAll(f)
+	if err != nil {
+		log.Printf("Failed to read local config: %s", err)
+		localConfig.err = err
+		return
+	}
+
+	md, err := toml.Decode(string(data), &localConfig)
 	if err != nil {
 		log.Printf("Failed to load local config: %s", err)
+		localConfig.err = err
 		return
 	}
 
 	localConfig.path = f.Name()
+	localConfig.meta = md
+	localConfig.data = string(data)
+
+	for i := range localConfig.Setups {
+		localConfig.Setups[i].origin = fmt.Sprintf("%s setups[%d]", localConfig.path, i)
+	}
 
 	log.Printf("Unknown config keys: %s", md.Undecoded())
 }
@@ -106,6 +138,11 @@ func (l *LocalConfig) Setup(crate *Crate) ([]*LocalSetup, error) {
 	return projects, nil
 }
 
+// Origin describes where the setup was defined in the local config.
+func (s *LocalSetup) Origin() string {
+	return s.origin
+}
+
 func (l *LocalConfig) Path() string {
 	return l.path
 }
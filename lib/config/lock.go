@@ -0,0 +1,115 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// lockName is the name of the lock file, which is kept next to the project
+// file.
+const lockName = ".wrlock"
+
+const lockHeader = "# Generated by 'wharfrat lock', pinning crate images to digests.\n\n"
+
+// Lock maps the images used by crates to the image references (including a
+// digest) that they should be replaced with.
+type Lock struct {
+	Images map[string]string `toml:"images"`
+	path   string
+}
+
+// loadLock loads the project's lock file, if it has one.
+func (p *Project) loadLock(fsys projectFS) error {
+	data, err := fsys.ReadFile(lockName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lock := &Lock{path: filepath.Join(filepath.Dir(p.path), lockName)}
+	if _, err := toml.Decode(data, lock); err != nil {
+		return Problems{ProblemFromError(lock.path, err)}
+	}
+
+	p.lock = lock
+
+	return nil
+}
+
+// Lock returns the project's lock, or nil if the project doesn't have a lock
+// file.
+func (p *Project) Lock() *Lock {
+	return p.lock
+}
+
+// NewLock returns an empty lock for the project.
+func NewLock(p *Project) *Lock {
+	return &Lock{
+		Images: map[string]string{},
+		path:   filepath.Join(filepath.Dir(p.path), lockName),
+	}
+}
+
+// Path returns the path of the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Pinned returns the pinned reference for image, or an empty string if the
+// image isn't in the lock.
+func (l *Lock) Pinned(image string) string {
+	return l.Images[image]
+}
+
+// Equal reports whether the two locks pin the same images.
+func (l *Lock) Equal(other *Lock) bool {
+	if other == nil || len(l.Images) != len(other.Images) {
+		return false
+	}
+	for image, pinned := range l.Images {
+		if other.Images[image] != pinned {
+			return false
+		}
+	}
+	return true
+}
+
+// Save writes the lock file.
+func (l *Lock) Save() error {
+	buf := &bytes.Buffer{}
+	buf.WriteString(lockHeader)
+	if err := toml.NewEncoder(buf).Encode(l); err != nil {
+		return fmt.Errorf("failed to encode lock: %w", err)
+	}
+	return os.WriteFile(l.path, buf.Bytes(), 0644)
+}
+
+// pin replaces the crate's image with the pinned image from the lock, if
+// there is one.
+func (c *Crate) pin() {
+	if c.project.lock == nil {
+		return
+	}
+	pinned := c.project.lock.Pinned(c.Image)
+	if pinned == "" {
+		return
+	}
+	c.floating = c.Image
+	c.Image = pinned
+	c.setOrigin("image", c.project.lock.path)
+}
+
+// FloatingImage returns the image for the crate before it was pinned by the
+// lock file.
+func (c *Crate) FloatingImage() string {
+	if c.floating != "" {
+		return c.floating
+	}
+	return c.Image
+}
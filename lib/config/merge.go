@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// crateKeys returns the toml key for each field of Crate that can be set from
+// a config file, indexed by field number.
+func crateKeys() map[int]string {
+	keys := map[int]string{}
+	t := reflect.TypeOf(Crate{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if key == "" || key == "-" || key == "branches" {
+			// branch overrides are applied separately, rather than merged
+			continue
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// definedKeys returns the set of crate keys explicitly set in the table found
+// at path in the decoded file described by md.
+func definedKeys(md toml.MetaData, path ...string) map[string]bool {
+	defined := map[string]bool{}
+	for _, key := range crateKeys() {
+		if md.IsDefined(append(path, key)...) {
+			defined[key] = true
+		}
+	}
+	return defined
+}
+
+// layer is a table of crate settings that is merged into a crate, along with
+// the file that it was loaded from.
+type layer struct {
+	src     source
+	table   toml.Key
+	defined map[string]bool
+}
+
+func newLayer(src source, table ...string) layer {
+	return layer{
+		src:     src,
+		table:   table,
+		defined: definedKeys(src.meta, table...),
+	}
+}
+
+// origin describes where the value for key was set in the layer.
+func (l layer) origin(key toml.Key) string {
+	path := append(append(toml.Key{}, l.table...), key...)
+	if line := keyLine(l.src.data, path); line > 0 {
+		return fmt.Sprintf("%s:%d", l.src.path, line)
+	}
+	return l.src.path
+}
+
+// mergeCrate returns a copy of parent with the keys that are defined in the
+// child layer merged on top. Lists are appended to the parent list, tables are
+// merged with child entries taking precedence and all other values are
+// replaced.
+func mergeCrate(parent, child *Crate, l layer) *Crate {
+	merged := *parent
+
+	dst := reflect.ValueOf(&merged).Elem()
+	src := reflect.ValueOf(child).Elem()
+
+	merged.defined = map[string]bool{}
+	for key := range parent.defined {
+		merged.defined[key] = true
+	}
+
+	merged.origins = map[string][]string{}
+	for key, origins := range parent.origins {
+		merged.origins[key] = origins
+	}
+
+	for i, key := range crateKeys() {
+		if !l.defined[key] {
+			continue
+		}
+		merged.defined[key] = true
+		dst.Field(i).Set(mergeValue(dst.Field(i), src.Field(i)))
+		merged.addOrigins(toml.Key{key}, src.Field(i), l)
+	}
+
+	return &merged
+}
+
+// addOrigins records the origin of value, which was set at key by l. Table
+// entries are recorded individually, and list origins are appended to the
+// existing origins.
+func (c *Crate) addOrigins(key toml.Key, value reflect.Value, l layer) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			c.addOrigins(key, value.Elem(), l)
+		}
+	case reflect.Struct:
+		for i, name := range tableKeys(value.Type()) {
+			entry := append(append(toml.Key{}, key...), name)
+			c.addOrigins(entry, value.Field(i), l)
+		}
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			entry := append(append(toml.Key{}, key...), iter.Key().String())
+			c.addOrigins(entry, iter.Value(), l)
+		}
+	case reflect.Slice:
+		name := key.String()
+		existing := c.origins[name]
+		c.origins[name] = append(existing[:len(existing):len(existing)], l.origin(key))
+	default:
+		c.origins[key.String()] = []string{l.origin(key)}
+	}
+}
+
+// tableKeys returns the toml key for each field of a struct that is used as a
+// table in a crate (e.g. build), indexed by field number.
+func tableKeys(t reflect.Type) map[int]string {
+	keys := map[int]string{}
+	for i := 0; i < t.NumField(); i++ {
+		if key := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]; key != "" {
+			keys[i] = key
+		}
+	}
+	return keys
+}
+
+func mergeValue(parent, child reflect.Value) reflect.Value {
+	switch child.Kind() {
+	case reflect.Slice:
+		if parent.Len() == 0 {
+			return child
+		}
+		merged := reflect.MakeSlice(child.Type(), 0, parent.Len()+child.Len())
+		merged = reflect.AppendSlice(merged, parent)
+		return reflect.AppendSlice(merged, child)
+	case reflect.Map:
+		if parent.Len() == 0 {
+			return child
+		}
+		merged := reflect.MakeMapWithSize(child.Type(), parent.Len()+child.Len())
+		iter := parent.MapRange()
+		for iter.Next() {
+			merged.SetMapIndex(iter.Key(), iter.Value())
+		}
+		iter = child.MapRange()
+		for iter.Next() {
+			value := iter.Value()
+			if existing := merged.MapIndex(iter.Key()); existing.IsValid() && value.Kind() == reflect.Map {
+				value = mergeValue(existing, value)
+			}
+			merged.SetMapIndex(iter.Key(), value)
+		}
+		return merged
+	default:
+		return child
+	}
+}
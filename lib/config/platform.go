@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// architectures are the linux architectures that images can be run on, using
+// the GOARCH names that docker uses.
+var architectures = map[string]bool{
+	"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+	"mips64le": true, "ppc64le": true, "riscv64": true, "s390x": true,
+}
+
+// parsePlatform parses a platform in the same format as the docker run
+// --platform option, OS/ARCH[/VARIANT]. Only linux platforms are supported.
+func parsePlatform(platform string) (*specs.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("expected OS/ARCH[/VARIANT]")
+	}
+	if parts[0] != "linux" {
+		return nil, fmt.Errorf("unsupported OS '%s'", parts[0])
+	}
+	if !architectures[parts[1]] {
+		return nil, fmt.Errorf("unknown architecture '%s'", parts[1])
+	}
+	parsed := &specs.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		parsed.Variant = parts[2]
+	}
+	return parsed, nil
+}
+
+func checkPlatform(platform string) []string {
+	if platform == "" {
+		return nil
+	}
+	if _, err := parsePlatform(platform); err != nil {
+		return []string{fmt.Sprintf("invalid platform '%s': %s", platform, err)}
+	}
+	return nil
+}
+
+// ImagePlatform returns the platform for the crate's image and container. If
+// the crate doesn't set a platform, then linux on the host's architecture is
+// used.
+func (c *Crate) ImagePlatform() (*specs.Platform, error) {
+	if c.Platform == "" {
+		return &specs.Platform{OS: "linux", Architecture: runtime.GOARCH}, nil
+	}
+	platform, err := parsePlatform(c.Platform)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform '%s': %w", c.Platform, err)
+	}
+	return platform, nil
+}
+
+// PlatformName returns the platform for the crate as OS/ARCH[/VARIANT].
+func (c *Crate) PlatformName() string {
+	if c.Platform == "" {
+		return "linux/" + runtime.GOARCH
+	}
+	return c.Platform
+}
@@ -0,0 +1,401 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// AllowDeny is a pair of lists restricting the values of a setting. A value
+// that matches the deny list is refused, and if there is an allow list then
+// the value must match it. An empty allow list allows nothing, while leaving
+// it out allows everything.
+type AllowDeny struct {
+	Allow []string `toml:"allow"`
+	Deny  []string `toml:"deny"`
+}
+
+// PolicyRules are the restrictions that the local config puts on what crates
+// may use.
+type PolicyRules struct {
+	CapAdd      AllowDeny `toml:"cap-add"`
+	DeviceRules AllowDeny `toml:"device-cgroup-rules"`
+	Devices     AllowDeny `toml:"devices"`
+	Network     AllowDeny `toml:"network"`
+	Registries  AllowDeny `toml:"registries"`
+	SecurityOpt AllowDeny `toml:"security-opt"`
+	Volumes     AllowDeny `toml:"volumes"`
+	ImageCmd    *bool     `toml:"image-cmd"`
+	Privileged  *bool     `toml:"privileged"`
+	SetupPrep   *bool     `toml:"setup-prep"`
+}
+
+// PolicyException overrides the policy rules that it sets for projects whose
+// path matches the Project regular expression.
+type PolicyException struct {
+	Project string `toml:"project"`
+	PolicyRules
+}
+
+type Policy struct {
+	PolicyRules
+	Exceptions []PolicyException `toml:"exceptions"`
+}
+
+// merge returns the rules with the values set in other replacing them.
+func (r PolicyRules) merge(other PolicyRules) PolicyRules {
+	lists := []struct{ dst, src *AllowDeny }{
+		{&r.CapAdd, &other.CapAdd},
+		{&r.DeviceRules, &other.DeviceRules},
+		{&r.Devices, &other.Devices},
+		{&r.Network, &other.Network},
+		{&r.Registries, &other.Registries},
+		{&r.SecurityOpt, &other.SecurityOpt},
+		{&r.Volumes, &other.Volumes},
+	}
+	for _, list := range lists {
+		if list.src.Allow != nil {
+			list.dst.Allow = list.src.Allow
+		}
+		if list.src.Deny != nil {
+			list.dst.Deny = list.src.Deny
+		}
+	}
+	for _, flag := range []struct{ dst, src **bool }{
+		{&r.ImageCmd, &other.ImageCmd},
+		{&r.Privileged, &other.Privileged},
+		{&r.SetupPrep, &other.SetupPrep},
+	} {
+		if *flag.src != nil {
+			*flag.dst = *flag.src
+		}
+	}
+	return r
+}
+
+// PolicyFor returns the policy rules for the project at path, with any
+// matching exceptions applied in order.
+func (l *LocalConfig) PolicyFor(project string) (PolicyRules, error) {
+	rules := l.Policy.PolicyRules
+	for i, exception := range l.Policy.Exceptions {
+		pattern := exception.Project
+		if pattern == "" {
+			pattern = ".*"
+		}
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return PolicyRules{}, fmt.Errorf("policy exceptions[%d]: invalid project pattern: %w", i, err)
+		}
+		if r.MatchString(project) {
+			rules = rules.merge(exception.PolicyRules)
+		}
+	}
+	return rules, nil
+}
+
+// capName returns the capability without the CAP_ prefix, in upper case.
+func capName(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+}
+
+// matchCap matches capabilities with or without the CAP_ prefix, with ALL
+// matching every capability.
+func matchCap(entry, value string) bool {
+	entry = capName(entry)
+	return entry == "ALL" || entry == capName(value)
+}
+
+// allowedCap checks a capability against the lists. A value of ALL adds every
+// capability, so it is refused by any deny entry, and needs ALL in the allow
+// list.
+func (ad AllowDeny) allowedCap(capability string) bool {
+	if capName(capability) != "ALL" {
+		return ad.allowed(capability, matchCap)
+	}
+	if len(ad.Deny) > 0 {
+		return false
+	}
+	return ad.allowed(capability, func(entry, value string) bool {
+		return capName(entry) == "ALL"
+	})
+}
+
+// matchPath matches paths that are the entry or inside it. Entries may use
+// environment variables (e.g. $HOME).
+func matchPath(entry, value string) bool {
+	entry = filepath.Clean(Expand(entry, os.Getenv))
+	rel, err := filepath.Rel(entry, filepath.Clean(value))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// matchNetwork matches network modes, where an entry without a name (e.g.
+// container) matches every network of that kind (e.g. container:NAME).
+func matchNetwork(entry, value string) bool {
+	if strings.EqualFold(entry, value) {
+		return true
+	}
+	mode, _, named := strings.Cut(value, ":")
+	return named && strings.EqualFold(entry, mode)
+}
+
+// matchSecurityOpt matches security options, where an entry without a value
+// (e.g. seccomp) matches the option with any value (e.g. seccomp=unconfined).
+// Options in the old KEY:VALUE form match the same as KEY=VALUE.
+func matchSecurityOpt(entry, value string) bool {
+	entry, value = normalSecurityOpt(entry), normalSecurityOpt(value)
+	if entry == value {
+		return true
+	}
+	key, _, _ := strings.Cut(value, "=")
+	return !strings.Contains(entry, "=") && entry == key
+}
+
+// deviceRule is a parsed device cgroup rule, TYPE MAJOR:MINOR PERMISSIONS.
+type deviceRule struct {
+	kind, major, minor, perms string
+}
+
+func parseDeviceRule(rule string) (deviceRule, bool) {
+	if !deviceCgroupRule.MatchString(rule) {
+		return deviceRule{}, false
+	}
+	fields := strings.Fields(rule)
+	major, minor, _ := strings.Cut(fields[1], ":")
+	return deviceRule{fields[0], major, minor, fields[2]}, true
+}
+
+// covers reports whether every access that other grants is granted by r.
+func (r deviceRule) covers(other deviceRule) bool {
+	return (r.kind == "a" || r.kind == other.kind) &&
+		(r.major == "*" || r.major == other.major) &&
+		(r.minor == "*" || r.minor == other.minor) &&
+		strings.Trim(other.perms, r.perms) == ""
+}
+
+// overlaps reports whether r and other grant any of the same access.
+func (r deviceRule) overlaps(other deviceRule) bool {
+	return (r.kind == "a" || other.kind == "a" || r.kind == other.kind) &&
+		(r.major == "*" || other.major == "*" || r.major == other.major) &&
+		(r.minor == "*" || other.minor == "*" || r.minor == other.minor) &&
+		strings.ContainsAny(r.perms, other.perms)
+}
+
+// checkDeviceRules returns a message for each entry that isn't a valid
+// device cgroup rule.
+func (ad AllowDeny) checkDeviceRules() []string {
+	return append(checkDeviceCgroupRules(ad.Allow), checkDeviceCgroupRules(ad.Deny)...)
+}
+
+// allowedDeviceRule checks a device cgroup rule against the lists. A rule is
+// refused if it grants any access that a deny entry matches, and needs an
+// allow entry granting everything that it does. Invalid rules only match
+// when there are no lists.
+func (ad AllowDeny) allowedDeviceRule(rule string) bool {
+	parsed, ok := parseDeviceRule(rule)
+	if !ok {
+		return ad.Allow == nil && len(ad.Deny) == 0
+	}
+	for _, entry := range ad.Deny {
+		if e, ok := parseDeviceRule(entry); ok && e.overlaps(parsed) {
+			return false
+		}
+	}
+	if ad.Allow == nil {
+		return true
+	}
+	for _, entry := range ad.Allow {
+		if e, ok := parseDeviceRule(entry); ok && e.covers(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRegistry matches images from the registry named by the entry, or from
+// a repository path under it (e.g. ghcr.io/org).
+func matchRegistry(entry, value string) bool {
+	entry = strings.TrimSuffix(entry, "/")
+	return value == entry || strings.HasPrefix(value, entry+"/")
+}
+
+// allowed checks value against the lists using match.
+func (ad AllowDeny) allowed(value string, match func(entry, value string) bool) bool {
+	for _, entry := range ad.Deny {
+		if match(entry, value) {
+			return false
+		}
+	}
+	if ad.Allow == nil {
+		return true
+	}
+	for _, entry := range ad.Allow {
+		if match(entry, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedPath checks a host path against the lists. A relative path can't be
+// matched against the entries, so it is only allowed when there are no lists.
+func (ad AllowDeny) allowedPath(path string) bool {
+	if !filepath.IsAbs(path) {
+		return ad.Allow == nil && len(ad.Deny) == 0
+	}
+	return ad.allowed(path, matchPath)
+}
+
+// imageRepository returns the image name including the registry, e.g.
+// docker.io/library/debian.
+func imageRepository(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return named.Name()
+}
+
+// namedVolume matches the names that docker accepts for volumes.
+var namedVolume = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// hostPath returns the host side of a volume or device mapping, or an empty
+// string for named volumes.
+func hostPath(mapping string) string {
+	src := strings.Split(mapping, ":")[0]
+	if namedVolume.MatchString(src) {
+		return ""
+	}
+	return src
+}
+
+// policyProblem returns a problem for the crate key that breaks the policy.
+func (c *Crate) policyProblem(key, msg string) Problem {
+	if origins := c.Origins([]string{key}); len(origins) > 0 {
+		msg = fmt.Sprintf("%s (from %s)", msg, strings.Join(origins, ", "))
+	}
+	return Problem{
+		File:    c.project.SourcePath(c.name),
+		Crate:   c.name,
+		Message: msg,
+	}
+}
+
+// check returns a problem for each setting of the crate that breaks the
+// rules. The image is checked separately when it comes from image-cmd, since
+// the command can only be run once the rest of the crate has been checked.
+func (r PolicyRules) check(c *Crate) Problems {
+	problems := Problems{}
+	refuse := func(key, format string, args ...interface{}) {
+		msg := fmt.Sprintf("%s: %s is not allowed by the local policy", key, fmt.Sprintf(format, args...))
+		problems = append(problems, c.policyProblem(key, msg))
+	}
+
+	for _, capability := range c.CapAdd {
+		if !r.CapAdd.allowedCap(capability) {
+			refuse("cap-add", "'%s'", capability)
+		}
+	}
+
+	for _, device := range c.Devices {
+		if path := hostPath(device); path != "" && !r.Devices.allowedPath(path) {
+			refuse("devices", "'%s'", path)
+		}
+	}
+
+	for _, rule := range c.DeviceRules {
+		if !r.DeviceRules.allowedDeviceRule(rule) {
+			refuse("device-cgroup-rules", "'%s'", rule)
+		}
+	}
+
+	if c.Network != "" && !r.Network.allowed(c.Network, matchNetwork) {
+		refuse("network", "'%s'", c.Network)
+	}
+
+	for _, opt := range c.SecurityOpt {
+		if !r.SecurityOpt.allowed(opt, matchSecurityOpt) {
+			refuse("security-opt", "'%s'", opt)
+		}
+	}
+
+	// Tarballs are read from the host like volumes, relative to the project
+	// directory
+	projectDir := filepath.Dir(c.ProjectPath())
+	tarballs := make([]string, 0, len(c.Tarballs))
+	for src := range c.Tarballs {
+		tarballs = append(tarballs, src)
+	}
+	sort.Strings(tarballs)
+	for _, src := range tarballs {
+		path := src
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectDir, path)
+		}
+		if !r.Volumes.allowedPath(path) {
+			refuse("tarballs", "host path '%s'", path)
+		}
+	}
+
+	for _, volume := range c.Volumes {
+		if path := hostPath(volume); path != "" && !r.Volumes.allowedPath(path) {
+			refuse("volumes", "host path '%s'", path)
+		}
+	}
+
+	if c.Build == nil && c.ImageCmd == "" {
+		problems = append(problems, r.checkImage(c)...)
+	}
+
+	if c.ImageCmd != "" && c.Build == nil && r.ImageCmd != nil && !*r.ImageCmd {
+		refuse("image-cmd", "running a command on the host")
+	}
+
+	if c.Privileged && r.Privileged != nil && !*r.Privileged {
+		refuse("privileged", "a privileged container")
+	}
+
+	if c.SetupPrep != "" && r.SetupPrep != nil && !*r.SetupPrep {
+		refuse("setup-prep", "running a script on the host")
+	}
+
+	return problems
+}
+
+// checkImage returns a problem if the crate's image comes from a registry
+// that the rules don't allow.
+func (r PolicyRules) checkImage(c *Crate) Problems {
+	if c.Image == "" {
+		return nil
+	}
+	repo := imageRepository(c.Image)
+	if r.Registries.allowed(repo, matchRegistry) {
+		return nil
+	}
+	msg := fmt.Sprintf("image: '%s' is not from a registry allowed by the local policy", c.Image)
+	return Problems{c.policyProblem("image", msg)}
+}
+
+// CheckPolicy checks the crate against the local policy for its project,
+// returning the settings that aren't allowed as Problems.
+func (c *Crate) CheckPolicy() error {
+	rules, err := Local().PolicyFor(c.ProjectPath())
+	if err != nil {
+		return err
+	}
+
+	problems := rules.check(c)
+	if c.Build == nil && c.ImageCmd != "" {
+		problems = append(problems, rules.checkImage(c)...)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchers(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+
+	tests := []struct {
+		name  string
+		match func(entry, value string) bool
+		entry string
+		value string
+		want  bool
+	}{
+		{"cap", matchCap, "SYS_ADMIN", "SYS_ADMIN", true},
+		{"cap", matchCap, "CAP_SYS_ADMIN", "sys_admin", true},
+		{"cap", matchCap, "sys_admin", "CAP_SYS_ADMIN", true},
+		{"cap", matchCap, "ALL", "NET_ADMIN", true},
+		{"cap", matchCap, "SYS_ADMIN", "NET_ADMIN", false},
+
+		{"path", matchPath, "/data", "/data", true},
+		{"path", matchPath, "/data", "/data/sub/dir", true},
+		{"path", matchPath, "/data/", "/data/sub", true},
+		{"path", matchPath, "/data", "/database", false},
+		{"path", matchPath, "/data", "/data/../etc", false},
+		{"path", matchPath, "$HOME/src", "/home/me/src/project", true},
+		{"path", matchPath, "$HOME/src", "/home/me/.ssh", false},
+		{"path", matchPath, "/", "/etc", true},
+
+		{"network", matchNetwork, "host", "host", true},
+		{"network", matchNetwork, "HOST", "host", true},
+		{"network", matchNetwork, "container", "container:other", true},
+		{"network", matchNetwork, "container:other", "container:other", true},
+		{"network", matchNetwork, "container:other", "container:mine", false},
+		{"network", matchNetwork, "host", "bridge", false},
+
+		{"security-opt", matchSecurityOpt, "seccomp=unconfined", "seccomp=unconfined", true},
+		{"security-opt", matchSecurityOpt, "seccomp=unconfined", "seccomp:unconfined", true},
+		{"security-opt", matchSecurityOpt, "seccomp:unconfined", "seccomp=unconfined", true},
+		{"security-opt", matchSecurityOpt, "seccomp", "seccomp=profile.json", true},
+		{"security-opt", matchSecurityOpt, "seccomp", "seccomp:unconfined", true},
+		{"security-opt", matchSecurityOpt, "apparmor=unconfined", "apparmor:unconfined", true},
+		{"security-opt", matchSecurityOpt, "seccomp=unconfined", "seccomp=profile.json", false},
+		{"security-opt", matchSecurityOpt, "seccomp", "apparmor=unconfined", false},
+		{"security-opt", matchSecurityOpt, "no-new-privileges", "no-new-privileges", true},
+
+		{"registry", matchRegistry, "docker.io", "docker.io/library/debian", true},
+		{"registry", matchRegistry, "ghcr.io/org", "ghcr.io/org/image", true},
+		{"registry", matchRegistry, "ghcr.io/org/", "ghcr.io/org/image", true},
+		{"registry", matchRegistry, "ghcr.io/org", "ghcr.io/organisation/image", false},
+		{"registry", matchRegistry, "docker.io", "quay.io/docker.io/image", false},
+	}
+
+	for _, test := range tests {
+		if got := test.match(test.entry, test.value); got != test.want {
+			t.Errorf("%s: match(%q, %q): got %v, want %v", test.name, test.entry, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		lists AllowDeny
+		value string
+		want  bool
+	}{
+		{AllowDeny{}, "host", true},
+		{AllowDeny{Deny: []string{"host"}}, "host", false},
+		{AllowDeny{Deny: []string{"host"}}, "bridge", true},
+		{AllowDeny{Allow: []string{}}, "bridge", false},
+		{AllowDeny{Allow: []string{"bridge"}}, "bridge", true},
+		{AllowDeny{Allow: []string{"bridge"}}, "host", false},
+		{AllowDeny{Allow: []string{"host"}, Deny: []string{"host"}}, "host", false},
+	}
+
+	for _, test := range tests {
+		if got := test.lists.allowed(test.value, matchNetwork); got != test.want {
+			t.Errorf("%+v: allowed(%q): got %v, want %v", test.lists, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllowedCap(t *testing.T) {
+	tests := []struct {
+		lists AllowDeny
+		value string
+		want  bool
+	}{
+		{AllowDeny{}, "ALL", true},
+		{AllowDeny{Deny: []string{"SYS_ADMIN"}}, "CAP_SYS_ADMIN", false},
+		{AllowDeny{Deny: []string{"SYS_ADMIN"}}, "NET_ADMIN", true},
+		{AllowDeny{Deny: []string{"SYS_ADMIN"}}, "ALL", false},
+		{AllowDeny{Allow: []string{"SYS_PTRACE"}}, "ALL", false},
+		{AllowDeny{Allow: []string{"ALL"}}, "ALL", true},
+		{AllowDeny{Allow: []string{"ALL"}}, "SYS_ADMIN", true},
+	}
+
+	for _, test := range tests {
+		if got := test.lists.allowedCap(test.value); got != test.want {
+			t.Errorf("%+v: allowedCap(%q): got %v, want %v", test.lists, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllowedPath(t *testing.T) {
+	tests := []struct {
+		lists AllowDeny
+		value string
+		want  bool
+	}{
+		{AllowDeny{}, "relative", true},
+		{AllowDeny{Deny: []string{"/etc"}}, "relative", false},
+		{AllowDeny{Allow: []string{"/src"}}, "../src", false},
+		{AllowDeny{Allow: []string{"/src"}}, "/src/project", true},
+		{AllowDeny{Allow: []string{"/src"}, Deny: []string{"/src/secret"}}, "/src/secret/key", false},
+	}
+
+	for _, test := range tests {
+		if got := test.lists.allowedPath(test.value); got != test.want {
+			t.Errorf("%+v: allowedPath(%q): got %v, want %v", test.lists, test.value, got, test.want)
+		}
+	}
+}
+
+func TestAllowedDeviceRule(t *testing.T) {
+	tests := []struct {
+		lists AllowDeny
+		value string
+		want  bool
+	}{
+		{AllowDeny{}, "c *:* rwm", true},
+		{AllowDeny{Deny: []string{"c 189:* rwm"}}, "c 189:3 r", false},
+		{AllowDeny{Deny: []string{"c 189:* rwm"}}, "c *:* rwm", false},
+		{AllowDeny{Deny: []string{"c 189:* rwm"}}, "a *:* m", false},
+		{AllowDeny{Deny: []string{"c 189:* rwm"}}, "b 189:3 rwm", true},
+		{AllowDeny{Deny: []string{"c 189:* rwm"}}, "c 1:3 rwm", true},
+		{AllowDeny{Deny: []string{"c 189:* w"}}, "c 189:3 r", true},
+		{AllowDeny{Allow: []string{"c 189:* rw"}}, "c 189:3 r", true},
+		{AllowDeny{Allow: []string{"c 189:* rw"}}, "c 189:3 rwm", false},
+		{AllowDeny{Allow: []string{"c 189:* rw"}}, "c *:* r", false},
+		{AllowDeny{Allow: []string{"a *:* rwm"}}, "b 8:0 r", true},
+		{AllowDeny{Allow: []string{"c *:* rwm"}}, "a *:* r", false},
+		{AllowDeny{Deny: []string{"c 1:3 r"}}, "invalid", false},
+	}
+
+	for _, test := range tests {
+		if got := test.lists.allowedDeviceRule(test.value); got != test.want {
+			t.Errorf("%+v: allowedDeviceRule(%q): got %v, want %v", test.lists, test.value, got, test.want)
+		}
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	tests := []struct {
+		mapping string
+		want    string
+	}{
+		{"/host:/container", "/host"},
+		{"/host:/container:ro", "/host"},
+		{"cache:/cache", ""},
+		{"./relative:/container", "./relative"},
+		{"/anonymous", "/anonymous"},
+		{"/dev/ttyUSB0", "/dev/ttyUSB0"},
+	}
+
+	for _, test := range tests {
+		if got := hostPath(test.mapping); got != test.want {
+			t.Errorf("hostPath(%q): got %q, want %q", test.mapping, got, test.want)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	no := false
+	yes := true
+	local := &LocalConfig{
+		Policy: Policy{
+			PolicyRules: PolicyRules{
+				Network:    AllowDeny{Deny: []string{"host"}},
+				Volumes:    AllowDeny{Allow: []string{"/src"}},
+				Privileged: &no,
+			},
+			Exceptions: []PolicyException{
+				{Project: "^/src/kernel/", PolicyRules: PolicyRules{Privileged: &yes}},
+				{Project: "^/src/kernel/", PolicyRules: PolicyRules{Volumes: AllowDeny{Allow: []string{"/lib/modules"}}}},
+			},
+		},
+	}
+
+	rules, err := local.PolicyFor("/src/other/wharfrat.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rules, local.Policy.PolicyRules) {
+		t.Errorf("other project: got %+v, want the top level rules", rules)
+	}
+
+	rules, err = local.PolicyFor("/src/kernel/wharfrat.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PolicyRules{
+		Network:    AllowDeny{Deny: []string{"host"}},
+		Volumes:    AllowDeny{Allow: []string{"/lib/modules"}},
+		Privileged: &yes,
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("kernel project: got %+v, want %+v", rules, want)
+	}
+
+	local.Policy.Exceptions = []PolicyException{{Project: "["}}
+	if _, err := local.PolicyFor("/src/kernel/wharfrat.toml"); err == nil {
+		t.Errorf("invalid project pattern: got no error")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	no := false
+	rules := PolicyRules{
+		CapAdd:      AllowDeny{Allow: []string{"SYS_PTRACE"}},
+		DeviceRules: AllowDeny{Deny: []string{"c 189:* rwm"}},
+		Devices:     AllowDeny{Deny: []string{"/dev/bus/usb"}},
+		Network:     AllowDeny{Deny: []string{"host"}},
+		Registries:  AllowDeny{Allow: []string{"docker.io"}},
+		SecurityOpt: AllowDeny{Deny: []string{"seccomp=unconfined"}},
+		Volumes:     AllowDeny{Allow: []string{"/src"}},
+		ImageCmd:    &no,
+		Privileged:  &no,
+		SetupPrep:   &no,
+	}
+	project := &Project{path: "/src/project/wharfrat.toml"}
+
+	allowed := &Crate{
+		project:     project,
+		name:        "allowed",
+		Image:       "debian:12",
+		CapAdd:      []string{"CAP_SYS_PTRACE"},
+		DeviceRules: []string{"c 1:3 rwm"},
+		Devices:     []string{"/dev/fuse"},
+		SecurityOpt: []string{"no-new-privileges"},
+		Tarballs:    map[string]string{"files.tar": "/"},
+		Volumes:     []string{"/src/other:/other", "cache:/cache"},
+	}
+	if problems := rules.check(allowed); len(problems) > 0 {
+		t.Errorf("allowed crate: got problems %v", problems)
+	}
+
+	refused := &Crate{
+		project:     project,
+		name:        "refused",
+		Image:       "ghcr.io/org/image",
+		CapAdd:      []string{"SYS_ADMIN"},
+		DeviceRules: []string{"c *:* rwm"},
+		Devices:     []string{"/dev/bus/usb/001:/dev/usb"},
+		Network:     "host",
+		Privileged:  true,
+		SecurityOpt: []string{"seccomp:unconfined"},
+		SetupPrep:   "true",
+		Tarballs:    map[string]string{"/etc/files.tar": "/"},
+		Volumes:     []string{"/etc:/host-etc"},
+	}
+	keys := []string{}
+	for _, problem := range rules.check(refused) {
+		if problem.File != project.path || problem.Crate != "refused" {
+			t.Errorf("problem %q: got file %q, crate %q", problem.Message, problem.File, problem.Crate)
+		}
+		key, _, _ := strings.Cut(problem.Message, ":")
+		keys = append(keys, key)
+	}
+	want := []string{"cap-add", "devices", "device-cgroup-rules", "network", "security-opt", "tarballs", "volumes", "image", "privileged", "setup-prep"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("refused crate: got problems for %q, want %q", keys, want)
+	}
+
+	// The image from image-cmd is checked once the command has been run
+	imageCmd := &Crate{project: project, name: "cmd", ImageCmd: "echo ghcr.io/org/image"}
+	if problems := rules.check(imageCmd); len(problems) != 1 || !strings.HasPrefix(problems[0].Message, "image-cmd:") {
+		t.Errorf("image-cmd crate: got problems %v, want only image-cmd", problems)
+	}
+}
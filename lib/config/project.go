@@ -1,45 +1,87 @@
 package config
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Project struct {
-	Default string
-	Crates  map[string]Crate
-	path    string
-	meta    toml.MetaData
+	Default  string
+	Defaults Crate    `toml:"defaults"`
+	Include  []string `toml:"include"`
+	Strict   bool     `toml:"strict"`
+	Crates   map[string]Crate
+	path     string
+	meta     toml.MetaData
+	data     string
+	sources  map[string]source
+	includes []source
+	lock     *Lock
+	vcBranch string
 }
 
 const NotFound = notFound("Not Found")
 
 func parse(path string) (*Project, error) {
-	var project Project
-	md, err := toml.DecodeFile(path, &project)
+	project, err := decodeProject(path)
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Unknown config keys: %s", md.Undecoded())
+	if project.Strict {
+		problems := project.Validate()
+		problems = append(problems, Local().Validate()...)
+		if len(problems) > 0 {
+			return nil, problems
+		}
+	}
+	return project, nil
+}
+
+// decodeProject loads the project file at path, along with any files that it
+// includes, without applying strict checking.
+func decodeProject(path string) (*Project, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	project, err := parseStr(string(data))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Unknown config keys: %s", project.meta.Undecoded())
 	log.Printf("Project File: %s", path)
-	log.Printf("Project: %#v", project)
 	project.path, err = filepath.Abs(path)
 	if err != nil {
 		return nil, err
 	}
-	project.meta = md
-	return &project, nil
+	fsys := dirFS(filepath.Dir(project.path))
+	if err := project.include(fsys); err != nil {
+		return nil, err
+	}
+	if err := project.loadLock(fsys); err != nil {
+		return nil, err
+	}
+	return project, nil
 }
 
 func parseStr(data string) (*Project, error) {
 	var project Project
-	_, err := toml.Decode(data, &project)
+	md, err := toml.Decode(data, &project)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Project: %#v", project)
+	project.data = data
+	if err := project.setMeta(md); err != nil {
+		return nil, err
+	}
 	return &project, nil
 }
 
@@ -53,4 +95,83 @@ func LocateProject(start string) (*Project, error) {
 
 func (p *Project) Path() string {
 	return p.path
-}
\ No newline at end of file
+}
+
+// setMeta records the metadata from decoding the project file, and checks that
+// the defaults table can be merged into each crate.
+func (p *Project) setMeta(md toml.MetaData) error {
+	if p.Defaults.Extends != "" {
+		return fmt.Errorf("defaults: extends is not supported")
+	}
+	p.meta = md
+	return nil
+}
+
+// resolve returns the named crate, with the project defaults, the settings
+// from any crates that it extends and any overrides matching branch merged in.
+// chain holds the names of the crates that led to this one being resolved, and
+// is used to detect cycles.
+func (p *Project) resolve(name, branch string, chain []string) (*Crate, error) {
+	crate, ok := p.Crates[name]
+	if !ok {
+		if len(chain) == 0 {
+			return nil, CrateNotFound
+		}
+		return nil, fmt.Errorf("crate %s: extends unknown crate %s", chain[len(chain)-1], name)
+	}
+
+	for _, seen := range chain {
+		if seen == name {
+			cycle := strings.Join(append(chain, name), " -> ")
+			return nil, fmt.Errorf("crate %s: extends cycle: %s", chain[0], cycle)
+		}
+	}
+
+	var parent *Crate
+	var err error
+	if crate.Extends == "" {
+		defaults := mergeCrate(&Crate{}, &p.Defaults, newLayer(p.mainSource(), "defaults"))
+		parent, err = applyBranches(defaults, &p.Defaults, branch, p.mainSource(), "defaults")
+	} else {
+		log.Printf("Crate %s extends %s", name, crate.Extends)
+		parent, err = p.resolve(crate.Extends, branch, append(chain, name))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	src := p.crateSource(name)
+	merged := mergeCrate(parent, &crate, newLayer(src, "crates", name))
+	return applyBranches(merged, &crate, branch, src, "crates", name)
+}
+
+// applyBranches merges the branch overrides from def whose pattern matches
+// branch into c. If more than one pattern matches, then the overrides are
+// applied in order of pattern.
+func applyBranches(c, def *Crate, branch string, src source, table ...string) (*Crate, error) {
+	if branch == "" || len(def.Branches) == 0 {
+		return c, nil
+	}
+
+	patterns := make([]string, 0, len(def.Branches))
+	for pattern := range def.Branches {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, branch)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid branch pattern '%s': %w", toml.Key(table), pattern, err)
+		}
+		if !matched {
+			continue
+		}
+		log.Printf("Branch %s matches %s.branches.%s", branch, toml.Key(table), pattern)
+		override := def.Branches[pattern]
+		overrideTable := append(append([]string{}, table...), "branches", pattern)
+		c = mergeCrate(c, &override, newLayer(src, overrideTable...))
+	}
+
+	return c, nil
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// resolveStr resolves the named crate from a project file holding data.
+func resolveStr(t *testing.T, data, name, branch string) (*Crate, error) {
+	t.Helper()
+	project, err := parseStr(data)
+	if err != nil {
+		t.Fatalf("failed to parse project: %s", err)
+	}
+	project.path = "/src/project/wharfrat.toml"
+	return project.resolve(name, branch, nil)
+}
+
+func TestMergeCrate(t *testing.T) {
+	crate, err := resolveStr(t, `
+[crates.base]
+image = "debian:12"
+volumes = ["/a:/a"]
+env = { A = "base", B = "base" }
+mount-home = true
+
+[crates.child]
+extends = "base"
+volumes = ["/b:/b"]
+env = { B = "child", C = "child" }
+mount-home = false
+`, "child", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if crate.Image != "debian:12" {
+		t.Errorf("image: got %q, want inherited debian:12", crate.Image)
+	}
+	if want := []string{"/a:/a", "/b:/b"}; !reflect.DeepEqual(crate.Volumes, want) {
+		t.Errorf("volumes: got %q, want %q", crate.Volumes, want)
+	}
+	if want := map[string]string{"A": "base", "B": "child", "C": "child"}; !reflect.DeepEqual(crate.Env, want) {
+		t.Errorf("env: got %v, want %v", crate.Env, want)
+	}
+	if crate.MountHome {
+		t.Errorf("mount-home: got true, want false set by child")
+	}
+	if want := []string{"/src/project/wharfrat.toml:4", "/src/project/wharfrat.toml:10"}; !reflect.DeepEqual(crate.origins["volumes"], want) {
+		t.Errorf("volumes origins: got %q, want %q", crate.origins["volumes"], want)
+	}
+}
+
+func TestResolveExtendsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "unknown",
+			data: "[crates.a]\nextends = \"missing\"\n",
+			want: "crate a: extends unknown crate missing",
+		},
+		{
+			name: "cycle",
+			data: "[crates.a]\nextends = \"b\"\n[crates.b]\nextends = \"a\"\n",
+			want: "crate a: extends cycle: a -> b -> a",
+		},
+		{
+			name: "self",
+			data: "[crates.a]\nextends = \"a\"\n",
+			want: "crate a: extends cycle: a -> a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := resolveStr(t, test.data, "a", "")
+			if err == nil || err.Error() != test.want {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	if _, err := resolveStr(t, "[crates.a]\n", "b", ""); err != CrateNotFound {
+		t.Errorf("missing crate: got error %v, want CrateNotFound", err)
+	}
+}
+
+func TestResolveDefaults(t *testing.T) {
+	data := `
+[defaults]
+image = "debian:12"
+caches = ["cargo"]
+env = { A = "defaults" }
+
+[crates.plain]
+
+[crates.own]
+image = "alpine"
+caches = ["npm"]
+env = { A = "own" }
+
+[crates.child]
+extends = "own"
+`
+	tests := []struct {
+		crate  string
+		image  string
+		caches []string
+		env    string
+	}{
+		{"plain", "debian:12", []string{"cargo"}, "defaults"},
+		{"own", "alpine", []string{"cargo", "npm"}, "own"},
+		// Defaults are only applied once, at the root of the extends chain
+		{"child", "alpine", []string{"cargo", "npm"}, "own"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.crate, func(t *testing.T) {
+			crate, err := resolveStr(t, data, test.crate, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crate.Image != test.image {
+				t.Errorf("image: got %q, want %q", crate.Image, test.image)
+			}
+			if !reflect.DeepEqual(crate.Caches, test.caches) {
+				t.Errorf("caches: got %q, want %q", crate.Caches, test.caches)
+			}
+			if crate.Env["A"] != test.env {
+				t.Errorf("env A: got %q, want %q", crate.Env["A"], test.env)
+			}
+		})
+	}
+
+	if _, err := parseStr("[defaults]\nextends = \"a\"\n"); err == nil {
+		t.Errorf("defaults with extends: got no error")
+	}
+}
+
+func TestResolveBranches(t *testing.T) {
+	data := `
+[defaults]
+env = { LEVEL = "defaults" }
+
+[defaults.branches."release/*"]
+env = { LEVEL = "defaults-release" }
+
+[crates.dev]
+image = "debian:12"
+volumes = ["/a:/a"]
+
+[crates.dev.branches."feature/*"]
+image = "debian:13"
+volumes = ["/b:/b"]
+
+[crates.dev.branches."*"]
+env = { ANY = "yes" }
+
+[crates.dev.branches."feature/x"]
+image = "debian:sid"
+`
+	tests := []struct {
+		branch  string
+		image   string
+		volumes []string
+		env     map[string]string
+	}{
+		{"", "debian:12", []string{"/a:/a"}, map[string]string{"LEVEL": "defaults"}},
+		{"main", "debian:12", []string{"/a:/a"}, map[string]string{"LEVEL": "defaults", "ANY": "yes"}},
+		{"feature/y", "debian:13", []string{"/a:/a", "/b:/b"}, map[string]string{"LEVEL": "defaults"}},
+		// Patterns are applied in sorted order, so feature/x wins over feature/*
+		{"feature/x", "debian:sid", []string{"/a:/a", "/b:/b"}, map[string]string{"LEVEL": "defaults"}},
+		{"release/1", "debian:12", []string{"/a:/a"}, map[string]string{"LEVEL": "defaults-release"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.branch, func(t *testing.T) {
+			crate, err := resolveStr(t, data, "dev", test.branch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crate.Image != test.image {
+				t.Errorf("image: got %q, want %q", crate.Image, test.image)
+			}
+			if !reflect.DeepEqual(crate.Volumes, test.volumes) {
+				t.Errorf("volumes: got %q, want %q", crate.Volumes, test.volumes)
+			}
+			if !reflect.DeepEqual(crate.Env, test.env) {
+				t.Errorf("env: got %v, want %v", crate.Env, test.env)
+			}
+		})
+	}
+
+	if _, err := resolveStr(t, "[crates.a.branches.\"[\"]\n", "a", "main"); err == nil {
+		t.Errorf("invalid pattern: got no error")
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// The pull policies, which decide when the image for a crate is pulled.
+const (
+	PullAlways  = "always"
+	PullMissing = "missing"
+	PullNever   = "never"
+	PullDaily   = "daily"
+)
+
+const pullsFilename = "pulls.json"
+
+func checkPullPolicy(policy string) []string {
+	switch policy {
+	case "", PullAlways, PullMissing, PullNever, PullDaily:
+		return nil
+	}
+	return []string{fmt.Sprintf("invalid pull-policy '%s': expected always, missing, never or daily", policy)}
+}
+
+// ImagePullPolicy returns the pull policy for the crate. If the crate doesn't
+// set one, then the local config is used, and if that doesn't set one either
+// then the image is only pulled when it is missing.
+func (c *Crate) ImagePullPolicy() string {
+	if c.PullPolicy != "" {
+		return c.PullPolicy
+	}
+	if policy := Local().PullPolicy; policy != "" {
+		return policy
+	}
+	return PullMissing
+}
+
+// LastPull returns when the named image was last pulled, or the zero time if
+// it hasn't been pulled by wharfrat.
+func LastPull(image string) time.Time {
+	pulls := map[string]time.Time{}
+	if err := load(pullsFilename, &pulls); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to load pull times: %s", err)
+	}
+	return pulls[image]
+}
+
+// RecordPull records that the named image has just been pulled.
+func RecordPull(image string) error {
+	pulls := map[string]time.Time{}
+	if err := load(pullsFilename, &pulls); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to load pull times: %s", err)
+	}
+	pulls[image] = time.Now()
+	return save(pullsFilename, pulls)
+}
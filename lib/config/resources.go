@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// Quantity is a setting that can be given as either a string or a number in
+// the config file (e.g. memory = "4g" or cpus = 1.5).
+type Quantity string
+
+func (q *Quantity) UnmarshalTOML(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		*q = Quantity(v)
+	case int64:
+		*q = Quantity(strconv.FormatInt(v, 10))
+	case float64:
+		*q = Quantity(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("expected a string or number, got %T", value)
+	}
+	return nil
+}
+
+// parseSize parses a size with an optional unit suffix (e.g. "512m" or "4g"),
+// returning 0 if the size is empty.
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	return units.RAMInBytes(size)
+}
+
+// parseSwap parses a memory-swap size, which can also be -1 for unlimited
+// swap.
+func parseSwap(size string) (int64, error) {
+	if strings.TrimSpace(size) == "-1" {
+		return -1, nil
+	}
+	return parseSize(size)
+}
+
+// parseCpus parses a (possibly fractional) number of CPUs into the number of
+// nano CPUs used by docker.
+func parseCpus(cpus string) (int64, error) {
+	if cpus == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("expected a positive number")
+	}
+	return int64(value * 1e9), nil
+}
+
+// parseUlimits parses a table mapping limit names to "SOFT[:HARD]" values,
+// returning the limits sorted by name.
+func parseUlimits(ulimits map[string]Quantity) ([]*container.Ulimit, error) {
+	names := make([]string, 0, len(ulimits))
+	for name := range ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parsed := make([]*container.Ulimit, 0, len(names))
+	for _, name := range names {
+		ulimit, err := units.ParseUlimit(name + "=" + string(ulimits[name]))
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, ulimit)
+	}
+	return parsed, nil
+}
+
+func checkResource(key string, value Quantity, parse func(string) (int64, error)) []string {
+	if _, err := parse(string(value)); err != nil {
+		return []string{fmt.Sprintf("invalid %s '%s': %s", key, value, err)}
+	}
+	return nil
+}
+
+func checkUlimits(ulimits map[string]Quantity) []string {
+	msgs := []string{}
+	for name, value := range ulimits {
+		if _, err := units.ParseUlimit(name + "=" + string(value)); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid ulimit '%s': %s", name, err))
+		}
+	}
+	sort.Strings(msgs)
+	return msgs
+}
+
+// Resources returns the resource limits for the crate's container, along with
+// the size of /dev/shm.
+func (c *Crate) Resources() (container.Resources, int64, error) {
+	resources := container.Resources{
+		CpusetCpus: c.Cpuset,
+	}
+
+	var err error
+	if resources.Memory, err = parseSize(string(c.Memory)); err != nil {
+		return resources, 0, fmt.Errorf("invalid memory '%s': %w", c.Memory, err)
+	}
+	if resources.MemorySwap, err = parseSwap(string(c.MemorySwap)); err != nil {
+		return resources, 0, fmt.Errorf("invalid memory-swap '%s': %w", c.MemorySwap, err)
+	}
+	if resources.NanoCPUs, err = parseCpus(string(c.Cpus)); err != nil {
+		return resources, 0, fmt.Errorf("invalid cpus '%s': %w", c.Cpus, err)
+	}
+	if resources.Ulimits, err = parseUlimits(c.Ulimits); err != nil {
+		return resources, 0, fmt.Errorf("invalid ulimits: %w", err)
+	}
+	if c.PidsLimit != 0 {
+		limit := c.PidsLimit
+		resources.PidsLimit = &limit
+	}
+
+	shmSize, err := parseSize(string(c.ShmSize))
+	if err != nil {
+		return resources, 0, fmt.Errorf("invalid shm-size '%s': %w", c.ShmSize, err)
+	}
+
+	return resources, shmSize, nil
+}
+
+// ResourceSummary returns a short description of the resource limits set for
+// the crate, or "none" if there are no limits.
+func (c *Crate) ResourceSummary() string {
+	parts := []string{}
+	add := func(key string, value Quantity) {
+		if value != "" {
+			parts = append(parts, key+"="+string(value))
+		}
+	}
+
+	add("memory", c.Memory)
+	add("memory-swap", c.MemorySwap)
+	add("cpus", c.Cpus)
+	add("cpuset", Quantity(c.Cpuset))
+	if c.PidsLimit != 0 {
+		add("pids-limit", Quantity(strconv.FormatInt(c.PidsLimit, 10)))
+	}
+	add("shm-size", c.ShmSize)
+
+	names := make([]string, 0, len(c.Ulimits))
+	for name := range c.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add("ulimit."+name, c.Ulimits[name])
+	}
+
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"wharfr.at/wharfrat/lib/vc"
+)
+
+var deviceCgroupRule = regexp.MustCompile(`^[acb] ([0-9]+|\*):([0-9]+|\*) [rwm]{1,3}$`)
+
+// parseDevice parses a device in the same format as the docker run --device
+// option, HOST[:CONTAINER][:PERMISSIONS].
+func parseDevice(device string) (container.DeviceMapping, error) {
+	mapping := container.DeviceMapping{CgroupPermissions: "rwm"}
+
+	parts := strings.Split(device, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return mapping, fmt.Errorf("expected HOST[:CONTAINER][:PERMISSIONS]")
+	}
+
+	mapping.PathOnHost = parts[0]
+	mapping.PathInContainer = parts[0]
+	switch len(parts) {
+	case 3:
+		mapping.PathInContainer = parts[1]
+		mapping.CgroupPermissions = parts[2]
+	case 2:
+		if validPermissions(parts[1]) {
+			mapping.CgroupPermissions = parts[1]
+		} else {
+			mapping.PathInContainer = parts[1]
+		}
+	}
+
+	if !filepath.IsAbs(mapping.PathInContainer) {
+		return mapping, fmt.Errorf("container path should be absolute")
+	}
+	if !validPermissions(mapping.CgroupPermissions) {
+		return mapping, fmt.Errorf("invalid permissions '%s'", mapping.CgroupPermissions)
+	}
+
+	return mapping, nil
+}
+
+func validPermissions(perms string) bool {
+	if perms == "" || len(perms) > 3 {
+		return false
+	}
+	for _, c := range perms {
+		if !strings.ContainsRune("rwm", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkDevices(devices []string) []string {
+	msgs := []string{}
+	for _, device := range devices {
+		if _, err := parseDevice(device); err != nil && !unexpanded(device) {
+			msgs = append(msgs, fmt.Sprintf("invalid device '%s': %s", device, err))
+		}
+	}
+	return msgs
+}
+
+func checkDeviceCgroupRules(rules []string) []string {
+	msgs := []string{}
+	for _, rule := range rules {
+		if !deviceCgroupRule.MatchString(rule) {
+			msgs = append(msgs, fmt.Sprintf("invalid device-cgroup-rule '%s': expected 'TYPE MAJOR:MINOR PERMISSIONS'", rule))
+		}
+	}
+	return msgs
+}
+
+// splitSecurityOpt splits a security option into its key and value. Docker
+// still accepts the old KEY:VALUE form, so that is treated as KEY=VALUE.
+func splitSecurityOpt(opt string) (key, value string, found bool) {
+	if key, value, found := strings.Cut(opt, "="); found {
+		return key, value, found
+	}
+	return strings.Cut(opt, ":")
+}
+
+// normalSecurityOpt returns the security option in the KEY=VALUE form.
+func normalSecurityOpt(opt string) string {
+	key, value, found := splitSecurityOpt(opt)
+	if !found {
+		return opt
+	}
+	return key + "=" + value
+}
+
+func checkSecurityOpt(opts []string) []string {
+	msgs := []string{}
+	for _, opt := range opts {
+		key, _, found := splitSecurityOpt(opt)
+		switch {
+		case key == "no-new-privileges":
+		case !found:
+			msgs = append(msgs, fmt.Sprintf("invalid security-opt '%s': expected KEY=VALUE", opt))
+		case key == "seccomp", key == "apparmor", key == "label", key == "systempaths":
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid security-opt '%s': unknown option '%s'", opt, key))
+		}
+	}
+	return msgs
+}
+
+// DeviceMappings returns the device mappings for the crate's container.
+func (c *Crate) DeviceMappings() ([]container.DeviceMapping, error) {
+	mappings := make([]container.DeviceMapping, 0, len(c.Devices))
+	for _, device := range c.Devices {
+		mapping, err := parseDevice(device)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device '%s': %w", device, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// SecurityOpts returns the security options for the crate's container, in the
+// KEY=VALUE form. A seccomp profile is given as a path relative to the project directory, and
+// is loaded here since the docker daemon expects the profile itself.
+func (c *Crate) SecurityOpts() ([]string, error) {
+	opts := make([]string, 0, len(c.SecurityOpt))
+	for _, opt := range c.SecurityOpt {
+		key, value, _ := splitSecurityOpt(opt)
+		if key != "seccomp" || value == "unconfined" || value == "builtin" {
+			opts = append(opts, normalSecurityOpt(opt))
+			continue
+		}
+
+		path, data, err := c.seccompProfile(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load seccomp profile: %w", err)
+		}
+
+		profile := &bytes.Buffer{}
+		if err := json.Compact(profile, data); err != nil {
+			return nil, fmt.Errorf("failed to parse seccomp profile %s: %w", path, err)
+		}
+
+		opts = append(opts, "seccomp="+profile.String())
+	}
+	return opts, nil
+}
+
+// seccompProfile reads the seccomp profile at path, which is relative to the
+// project directory, from the branch the crate was read from if there is one.
+func (c *Crate) seccompProfile(path string) (string, []byte, error) {
+	projectDir := filepath.Dir(c.ProjectPath())
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectDir, path)
+	}
+
+	if c.project.vcBranch != "" {
+		if rel, err := filepath.Rel(projectDir, path); err == nil && filepath.IsLocal(rel) {
+			data, err := vc.BranchedFileIn(projectDir, rel, c.project.vcBranch)
+			return path, []byte(data), err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	return path, data, err
+}
+
+// hashSeccompProfiles records hashes of the crate's seccomp profiles, so that
+// editing a profile makes the container stale.
+func (c *Crate) hashSeccompProfiles() {
+	c.Seccomp = nil
+	for _, opt := range c.SecurityOpt {
+		key, value, _ := splitSecurityOpt(opt)
+		if key != "seccomp" || value == "unconfined" || value == "builtin" {
+			continue
+		}
+
+		hash := "missing"
+		if _, data, err := c.seccompProfile(value); err != nil {
+			log.Printf("SECCOMP: failed to read %s: %s", value, err)
+		} else {
+			sum := sha256.Sum256(data)
+			hash = hex.EncodeToString(sum[:])
+		}
+		c.Seccomp = append(c.Seccomp, hash)
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckSecurityOpt(t *testing.T) {
+	valid := []string{"no-new-privileges", "no-new-privileges:true", "seccomp=unconfined", "seccomp:unconfined", "apparmor=profile", "label:disable"}
+	if msgs := checkSecurityOpt(valid); len(msgs) > 0 {
+		t.Errorf("valid options: got %q", msgs)
+	}
+
+	invalid := []string{"seccomp", "unknown=1", "unknown:1"}
+	if msgs := checkSecurityOpt(invalid); len(msgs) != len(invalid) {
+		t.Errorf("invalid options: got %q, want %d message(s)", msgs, len(invalid))
+	}
+}
+
+func TestSecurityOpts(t *testing.T) {
+	crate := &Crate{
+		project:     &Project{path: "/src/project/wharfrat.toml"},
+		SecurityOpt: []string{"no-new-privileges", "seccomp:unconfined", "apparmor:unconfined", "label=disable"},
+	}
+
+	opts, err := crate.SecurityOpts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"no-new-privileges", "seccomp=unconfined", "apparmor=unconfined", "label=disable"}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %q, want %q", opts, want)
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Setting is a single effective crate setting, along with where its value came
+// from.
+type Setting struct {
+	Key     toml.Key
+	Value   interface{}
+	Origins []string
+}
+
+// Settings returns the effective settings of the crate, including env values
+// from the given local setups. Tables are flattened into their entries, and
+// empty values are skipped.
+func (c *Crate) Settings(locals []*LocalSetup) []Setting {
+	keys := crateKeys()
+	fields := make([]int, 0, len(keys))
+	for i := range keys {
+		fields = append(fields, i)
+	}
+	sort.Ints(fields)
+
+	v := reflect.ValueOf(c).Elem()
+	settings := []Setting{}
+	for _, i := range fields {
+		settings = c.appendSettings(settings, toml.Key{keys[i]}, v.Field(i))
+	}
+
+	for _, local := range locals {
+		names := make([]string, 0, len(local.Env))
+		for name := range local.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			setting := Setting{
+				Key:     toml.Key{"env", name},
+				Value:   Expand(local.Env[name], c.Getenv),
+				Origins: []string{local.origin},
+			}
+			settings = replaceSetting(settings, setting)
+		}
+	}
+
+	return settings
+}
+
+func (c *Crate) appendSettings(settings []Setting, key toml.Key, value reflect.Value) []Setting {
+	switch value.Kind() {
+	case reflect.Map:
+		names := make([]string, 0, value.Len())
+		for _, k := range value.MapKeys() {
+			names = append(names, k.String())
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entry := append(append(toml.Key{}, key...), name)
+			settings = c.appendSettings(settings, entry, value.MapIndex(reflect.ValueOf(name)))
+		}
+		return settings
+	case reflect.Ptr:
+		if value.IsNil() {
+			return settings
+		}
+		return c.appendSettings(settings, key, value.Elem())
+	case reflect.Struct:
+		keys := tableKeys(value.Type())
+		fields := make([]int, 0, len(keys))
+		for i := range keys {
+			fields = append(fields, i)
+		}
+		sort.Ints(fields)
+		for _, i := range fields {
+			entry := append(append(toml.Key{}, key...), keys[i])
+			settings = c.appendSettings(settings, entry, value.Field(i))
+		}
+		return settings
+	case reflect.Bool:
+		// false is a meaningful value for a bool, so they are always included
+	default:
+		if value.IsZero() || (value.Kind() == reflect.Slice && value.Len() == 0) {
+			return settings
+		}
+	}
+	return append(settings, Setting{
+		Key:     key,
+		Value:   value.Interface(),
+		Origins: c.Origins(key),
+	})
+}
+
+func replaceSetting(settings []Setting, setting Setting) []Setting {
+	for i, existing := range settings {
+		if existing.Key.String() == setting.Key.String() {
+			settings[i] = setting
+			return settings
+		}
+	}
+	return append(settings, setting)
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/moby/term"
+)
+
+const trustFilename = "trust.json"
+
+// declined holds the hashes of projects that the user has refused to trust,
+// so that they are only asked once.
+var declined = map[string]bool{}
+
+// HostAccess is a setting in a project file that runs commands on the host,
+// or gives a container access to host files outside of the project.
+type HostAccess struct {
+	Table string
+	Key   string
+	Value string
+}
+
+// loadTrust returns the approved hash for each project file.
+func loadTrust() map[string]string {
+	trusted := map[string]string{}
+	if err := load(trustFilename, &trusted); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to load trusted projects: %s", err)
+	}
+	return trusted
+}
+
+// Hash returns a hash of the content of the project file and the files that
+// it includes.
+func (p *Project) Hash() string {
+	h := sha256.New()
+	io.WriteString(h, p.data)
+	for _, inc := range p.includes {
+		rel, err := filepath.Rel(filepath.Dir(p.path), inc.path)
+		if err != nil {
+			rel = inc.path
+		}
+		fmt.Fprintf(h, "\x00%s\x00%s", rel, inc.data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HostAccess returns the settings in the project that run commands on the
+// host (image-cmd and setup-prep), read host files from outside the project
+// directory, or give containers access to the host (e.g. privileged, devices
+// and the agent and display sockets).
+func (p *Project) HostAccess() []HostAccess {
+	access := []HostAccess{}
+
+	var add func(table toml.Key, c *Crate)
+	add = func(table toml.Key, c *Crate) {
+		t := table.String()
+		if c.Build != nil && p.outsideProject(c.Build.Context) {
+			access = append(access, HostAccess{t, "build.context", c.Build.Context})
+		}
+		for _, cap := range c.CapAdd {
+			access = append(access, HostAccess{t, "cap-add", cap})
+		}
+		for _, device := range c.Devices {
+			access = append(access, HostAccess{t, "devices", device})
+		}
+		for _, rule := range c.DeviceRules {
+			access = append(access, HostAccess{t, "device-cgroup-rules", rule})
+		}
+		if c.GPGAgent {
+			access = append(access, HostAccess{t, "gpg-agent", "true"})
+		}
+		for _, feature := range c.GUI {
+			access = append(access, HostAccess{t, "gui", feature})
+		}
+		if c.ImageCmd != "" {
+			access = append(access, HostAccess{t, "image-cmd", c.ImageCmd})
+		}
+		if c.Network == "host" {
+			access = append(access, HostAccess{t, "network", c.Network})
+		}
+		if c.Privileged {
+			access = append(access, HostAccess{t, "privileged", "true"})
+		}
+		for _, opt := range c.SecurityOpt {
+			// Anything but no-new-privileges loosens the confinement, or
+			// reads a seccomp profile from the host
+			if opt != "no-new-privileges" {
+				access = append(access, HostAccess{t, "security-opt", opt})
+			}
+		}
+		if c.SetupPrep != "" {
+			access = append(access, HostAccess{t, "setup-prep", c.SetupPrep})
+		}
+		if c.SSHAgent {
+			access = append(access, HostAccess{t, "ssh-agent", "true"})
+		}
+		tarballs := make([]string, 0, len(c.Tarballs))
+		for src := range c.Tarballs {
+			tarballs = append(tarballs, src)
+		}
+		sort.Strings(tarballs)
+		for _, src := range tarballs {
+			if p.outsideProject(src) {
+				access = append(access, HostAccess{t, "tarballs", src})
+			}
+		}
+		for _, volume := range c.Volumes {
+			if p.hostVolume(volume) {
+				access = append(access, HostAccess{t, "volumes", volume})
+			}
+		}
+
+		patterns := make([]string, 0, len(c.Branches))
+		for pattern := range c.Branches {
+			patterns = append(patterns, pattern)
+		}
+		sort.Strings(patterns)
+		for _, pattern := range patterns {
+			override := c.Branches[pattern]
+			add(append(append(toml.Key{}, table...), "branches", pattern), &override)
+		}
+	}
+
+	add(toml.Key{"defaults"}, &p.Defaults)
+
+	names := make([]string, 0, len(p.Crates))
+	for name := range p.Crates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		crate := p.Crates[name]
+		add(toml.Key{"crates", name}, &crate)
+	}
+
+	return access
+}
+
+// hostVolume returns true if volume mounts a host path that isn't in the
+// project directory.
+func (p *Project) hostVolume(volume string) bool {
+	src := strings.Split(volume, ":")[0]
+	if !strings.ContainsAny(src, "/$~") {
+		// A named volume
+		return false
+	}
+	if !filepath.IsAbs(p.expandPath(src)) {
+		// Relative mounts aren't resolved against the project directory
+		return true
+	}
+	return p.outsideProject(src)
+}
+
+// outsideProject returns true if path, which is relative to the project
+// directory, isn't in the project directory.
+func (p *Project) outsideProject(path string) bool {
+	projectDir := filepath.Dir(p.path)
+	expanded := p.expandPath(path)
+	if !filepath.IsAbs(expanded) {
+		expanded = filepath.Join(projectDir, expanded)
+	}
+
+	rel, err := filepath.Rel(projectDir, filepath.Clean(expanded))
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// expandPath expands the environment variables in path using the current
+// environment.
+func (p *Project) expandPath(path string) string {
+	projectDir := filepath.Dir(p.path)
+	return Expand(path, func(name string) string {
+		switch name {
+		case "WHARFRAT_PROJECT":
+			return p.path
+		case "WHARFRAT_PROJECT_DIR":
+			return projectDir
+		}
+		return os.Getenv(name)
+	})
+}
+
+// ShowHostAccess writes a description of the project's host access to w.
+func (p *Project) ShowHostAccess(w io.Writer) {
+	access := p.HostAccess()
+	if len(access) == 0 {
+		fmt.Fprintf(w, "%s does not have any settings that affect the host\n", p.path)
+		return
+	}
+
+	for _, a := range access {
+		value := strings.TrimSpace(a.Value)
+		if strings.Contains(value, "\n") {
+			fmt.Fprintf(w, "%s.%s:\n", a.Table, a.Key)
+			for _, line := range strings.Split(value, "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		} else {
+			fmt.Fprintf(w, "%s.%s: %s\n", a.Table, a.Key, value)
+		}
+	}
+}
+
+// Trusted returns true if the project has been approved with its current
+// content, or doesn't need to be because it has no host access.
+func (p *Project) Trusted() bool {
+	if len(p.HostAccess()) == 0 {
+		return true
+	}
+
+	return loadTrust()[p.path] == p.Hash()
+}
+
+// Trust approves the current content of the project, replacing any earlier
+// approval.
+func (p *Project) Trust() error {
+	trusted := loadTrust()
+	hash := p.Hash()
+	if trusted[p.path] == hash {
+		return nil
+	}
+	trusted[p.path] = hash
+	delete(declined, hash)
+	return save(trustFilename, trusted)
+}
+
+// Untrust removes the approval for the project file at path, returning false
+// if there wasn't one.
+func Untrust(path string) (bool, error) {
+	trusted := loadTrust()
+	if _, found := trusted[path]; !found {
+		return false, nil
+	}
+	delete(trusted, path)
+	return true, save(trustFilename, trusted)
+}
+
+// checkTrust makes sure that the project has been approved before its
+// settings are used, asking the user if it hasn't. Without a terminal to ask
+// on, untrusted projects are refused.
+func (p *Project) checkTrust() error {
+	if p.Trusted() {
+		return nil
+	}
+
+	notTrusted := fmt.Errorf("%s is not trusted, run 'wharfrat trust' in %s to review and approve it", p.path, filepath.Dir(p.path))
+
+	hash := p.Hash()
+	if declined[hash] {
+		return notTrusted
+	}
+
+	_, inTerm := term.GetFdInfo(os.Stdin)
+	_, errTerm := term.GetFdInfo(os.Stderr)
+	if !inTerm || !errTerm {
+		return notTrusted
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is new or has changed, and has settings that affect the host:\n\n", p.path)
+	p.ShowHostAccess(os.Stderr)
+	fmt.Fprintf(os.Stderr, "\nTrust this project? [y/N] ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read answer: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return p.Trust()
+	}
+
+	declined[hash] = true
+	return notTrusted
+}
@@ -0,0 +1,453 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/docker/go-connections/nat"
+)
+
+// Problem describes an issue found when validating a config file.
+type Problem struct {
+	File    string
+	Line    int
+	Crate   string
+	Message string
+}
+
+func (p Problem) String() string {
+	b := &strings.Builder{}
+	b.WriteString(p.File)
+	if p.Line > 0 {
+		fmt.Fprintf(b, ":%d", p.Line)
+	}
+	b.WriteString(": ")
+	if p.Crate != "" {
+		fmt.Fprintf(b, "crate %s: ", p.Crate)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Problems is a list of Problem, which can be returned as an error.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	lines := make([]string, 0, len(p))
+	for _, problem := range p {
+		lines = append(lines, problem.String())
+	}
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+// ProblemFromError converts an error from loading a config file into a Problem,
+// keeping the line number if the error came from the TOML parser.
+func ProblemFromError(path string, err error) Problem {
+	parseErr := toml.ParseError{}
+	if errors.As(err, &parseErr) {
+		return Problem{File: path, Line: parseErr.Position.Line, Message: parseErr.Message}
+	}
+	return Problem{File: path, Message: err.Error()}
+}
+
+var volumeModes = map[string]bool{
+	"ro": true, "rw": true, "z": true, "Z": true, "nocopy": true,
+	"shared": true, "rshared": true, "slave": true, "rslave": true,
+	"private": true, "rprivate": true,
+	"delegated": true, "cached": true, "consistent": true,
+}
+
+// unexpanded reports whether value still contains variables that will only be
+// expanded when the container is created.
+func unexpanded(value string) bool {
+	found := false
+	Expand(value, func(string) string {
+		found = true
+		return ""
+	})
+	return found
+}
+
+func checkPorts(ports []string) []string {
+	msgs := []string{}
+	for _, port := range ports {
+		if _, _, err := nat.ParsePortSpecs([]string{port}); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid port '%s': %s", port, err))
+		}
+	}
+	return msgs
+}
+
+func checkCaches(caches []string) []string {
+	msgs := []string{}
+	for _, cache := range caches {
+		if !filepath.IsAbs(cache) && !unexpanded(cache) {
+			msgs = append(msgs, fmt.Sprintf("invalid cache '%s': path should be absolute", cache))
+		}
+	}
+	return msgs
+}
+
+func checkTmpfs(entries []string) []string {
+	msgs := []string{}
+	for _, entry := range entries {
+		dir := strings.SplitN(entry, ":", 2)[0]
+		if !filepath.IsAbs(dir) && !unexpanded(dir) {
+			msgs = append(msgs, fmt.Sprintf("invalid tmpfs '%s': path should be absolute", entry))
+		}
+	}
+	return msgs
+}
+
+func checkVolumes(volumes []string) []string {
+	msgs := []string{}
+	for _, volume := range volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) > 3 || parts[0] == "" {
+			msgs = append(msgs, fmt.Sprintf("invalid volume '%s': expected SRC[:DEST[:MODE]]", volume))
+			continue
+		}
+		dest := parts[0]
+		if len(parts) > 1 {
+			dest = parts[1]
+		}
+		if !filepath.IsAbs(dest) && !unexpanded(dest) {
+			msgs = append(msgs, fmt.Sprintf("invalid volume '%s': container path should be absolute", volume))
+		}
+		if len(parts) == 3 {
+			for _, mode := range strings.Split(parts[2], ",") {
+				if !volumeModes[mode] {
+					msgs = append(msgs, fmt.Sprintf("invalid volume '%s': unknown mode '%s'", volume, mode))
+				}
+			}
+		}
+	}
+	return msgs
+}
+
+func checkWorkingDir(workdir string) []string {
+	if strings.HasPrefix(workdir, "/") {
+		return nil
+	}
+	msgs := []string{}
+	for _, method := range strings.Split(workdir, ",") {
+		switch strings.TrimSpace(method) {
+		case "", "match", "project", "home":
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid working-dir '%s': unknown method '%s'", workdir, strings.TrimSpace(method)))
+		}
+	}
+	return msgs
+}
+
+func checkBuild(build *Build) []string {
+	if build == nil {
+		return nil
+	}
+	msgs := []string{}
+	if filepath.IsAbs(build.Dockerfile) {
+		msgs = append(msgs, fmt.Sprintf("invalid build dockerfile '%s': path should be relative to the context", build.Dockerfile))
+	}
+	return msgs
+}
+
+// checkCrate validates the values of a single crate definition, returning
+// problems keyed by the crate key that they relate to.
+func checkCrate(crate *Crate) map[string][]string {
+	return map[string][]string{
+		"build":               checkBuild(crate.Build),
+		"caches":              checkCaches(crate.Caches),
+		"cpus":                checkResource("cpus", crate.Cpus, parseCpus),
+		"device-cgroup-rules": checkDeviceCgroupRules(crate.DeviceRules),
+		"devices":             checkDevices(crate.Devices),
+		"gui":                 checkGUI(crate.GUI),
+		"memory":              checkResource("memory", crate.Memory, parseSize),
+		"memory-swap":         checkResource("memory-swap", crate.MemorySwap, parseSwap),
+		"platform":            checkPlatform(crate.Platform),
+		"ports":               checkPorts(crate.Ports),
+		"pull-policy":         checkPullPolicy(crate.PullPolicy),
+		"security-opt":        checkSecurityOpt(crate.SecurityOpt),
+		"shm-size":            checkResource("shm-size", crate.ShmSize, parseSize),
+		"tmpfs":               checkTmpfs(crate.Tmpfs),
+		"ulimits":             checkUlimits(crate.Ulimits),
+		"volumes":             checkVolumes(crate.Volumes),
+		"working-dir":         checkWorkingDir(crate.WorkingDir),
+	}
+}
+
+func (src source) problem(crate string, key toml.Key, msg string) Problem {
+	return Problem{
+		File:    src.path,
+		Line:    keyLine(src.data, key),
+		Crate:   crate,
+		Message: msg,
+	}
+}
+
+// setupProblem returns a problem for key in the index'th entry of the setups
+// array in the local config.
+func (src source) setupProblem(index int, key, msg string) Problem {
+	lines := strings.Split(src.data, "\n")
+	start := 0
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "[[setups]]") {
+			if index == 0 {
+				start = i
+				break
+			}
+			index--
+		}
+	}
+	line := keyLine(strings.Join(lines[start:], "\n"), toml.Key{"setups", key})
+	if line > 0 {
+		line += start
+	}
+	return Problem{File: src.path, Line: line, Message: msg}
+}
+
+func (src source) unknownKeys() []Problem {
+	problems := []Problem{}
+	for _, key := range src.meta.Undecoded() {
+		crate := ""
+		if len(key) > 2 && key[0] == "crates" {
+			crate = key[1]
+		}
+		problems = append(problems, src.problem(crate, key, fmt.Sprintf("unknown key '%s'", key)))
+	}
+	return problems
+}
+
+func (src source) checkCrate(name string, table toml.Key, crate *Crate) []Problem {
+	problems := []Problem{}
+	checks := checkCrate(crate)
+	keys := make([]string, 0, len(checks))
+	for key := range checks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, msg := range checks[key] {
+			problems = append(problems, src.problem(name, append(table, key), msg))
+		}
+	}
+	return problems
+}
+
+// checkBranches validates the branch overrides of a crate definition.
+func (src source) checkBranches(name string, table toml.Key, crate *Crate) []Problem {
+	patterns := make([]string, 0, len(crate.Branches))
+	for pattern := range crate.Branches {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	problems := []Problem{}
+	for _, pattern := range patterns {
+		override := crate.Branches[pattern]
+		overrideTable := append(append(toml.Key{}, table...), "branches", pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			msg := fmt.Sprintf("invalid branch pattern '%s': %s", pattern, err)
+			problems = append(problems, src.problem(name, overrideTable, msg))
+		}
+		if override.Extends != "" || len(override.Branches) > 0 {
+			msg := fmt.Sprintf("branch override '%s' cannot set extends or branches", pattern)
+			problems = append(problems, src.problem(name, overrideTable, msg))
+		}
+		problems = append(problems, src.checkCrate(name, overrideTable, &override)...)
+	}
+	return problems
+}
+
+// Validate checks the project, and any files it includes, for unknown keys and
+// invalid crate settings.
+func (p *Project) Validate() Problems {
+	main := p.mainSource()
+
+	problems := main.unknownKeys()
+	for _, src := range p.includes {
+		problems = append(problems, src.unknownKeys()...)
+	}
+
+	problems = append(problems, main.checkCrate("", toml.Key{"defaults"}, &p.Defaults)...)
+	problems = append(problems, main.checkBranches("", toml.Key{"defaults"}, &p.Defaults)...)
+
+	names := make([]string, 0, len(p.Crates))
+	for name := range p.Crates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		crate := p.Crates[name]
+		src := p.crateSource(name)
+		table := toml.Key{"crates", name}
+		problems = append(problems, src.checkCrate(name, table, &crate)...)
+		problems = append(problems, src.checkBranches(name, table, &crate)...)
+		if _, err := p.resolve(name, "", nil); err != nil {
+			problems = append(problems, src.problem("", append(table, "extends"), err.Error()))
+		}
+	}
+
+	return problems
+}
+
+// Validate checks the local config for load errors, unknown keys and invalid
+// setup patterns.
+func (l *LocalConfig) Validate() Problems {
+	path := l.path
+	if path == "" {
+		path = filepath.Join(configDir().Path, localName)
+	}
+
+	if l.err != nil {
+		return Problems{ProblemFromError(path, l.err)}
+	}
+
+	src := source{path: path, meta: l.meta, data: l.data}
+	problems := src.unknownKeys()
+
+	switch l.Runtime {
+	case "", RuntimeDocker, RuntimePodman:
+	default:
+		msg := fmt.Sprintf("invalid runtime '%s': expected docker or podman", l.Runtime)
+		problems = append(problems, src.problem("", toml.Key{"runtime"}, msg))
+	}
+
+	for _, msg := range checkPullPolicy(l.PullPolicy) {
+		problems = append(problems, src.problem("", toml.Key{"pull-policy"}, msg))
+	}
+
+	for _, msg := range l.Policy.DeviceRules.checkDeviceRules() {
+		problems = append(problems, src.problem("", toml.Key{"policy", "device-cgroup-rules"}, msg))
+	}
+
+	for i, exception := range l.Policy.Exceptions {
+		if _, err := regexp.Compile(exception.Project); err != nil {
+			msg := fmt.Sprintf("policy exceptions[%d]: invalid project pattern: %s", i, err)
+			problems = append(problems, src.problem("", toml.Key{"policy", "exceptions", "project"}, msg))
+		}
+		for _, msg := range exception.DeviceRules.checkDeviceRules() {
+			msg = fmt.Sprintf("policy exceptions[%d]: %s", i, msg)
+			problems = append(problems, src.problem("", toml.Key{"policy", "exceptions", "device-cgroup-rules"}, msg))
+		}
+	}
+
+	for i, setup := range l.Setups {
+		if _, err := regexp.Compile(setup.Project); err != nil {
+			msg := fmt.Sprintf("setups[%d]: invalid project pattern: %s", i, err)
+			problems = append(problems, src.setupProblem(i, "project", msg))
+		}
+		if _, err := regexp.Compile(setup.Crate); err != nil {
+			msg := fmt.Sprintf("setups[%d]: invalid crate pattern: %s", i, err)
+			problems = append(problems, src.setupProblem(i, "crate", msg))
+		}
+	}
+
+	return problems
+}
+
+// ValidateProject loads the project found from start and checks it, returning
+// the problems found. Unlike loading the project normally, the problems are
+// always collected, even if the project is not set to strict.
+func ValidateProject(start string) (string, Problems, error) {
+	path, err := find(start, ".wrproject")
+	if err != nil {
+		return "", nil, err
+	}
+
+	project, err := decodeProject(path)
+	if problems := (Problems)(nil); errors.As(err, &problems) {
+		return path, problems, nil
+	} else if err != nil {
+		return path, Problems{ProblemFromError(path, err)}, nil
+	}
+
+	return path, project.Validate(), nil
+}
+
+// keyLine returns the line in data where key is defined, or where the closest
+// enclosing table is defined if the key itself can't be found. If nothing can
+// be found then 0 is returned.
+func keyLine(data string, key toml.Key) int {
+	best, bestLen := 0, 0
+	table := toml.Key{}
+	closer := ""
+	depth := 0
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+
+		if closer != "" {
+			if strings.Contains(line, closer) {
+				closer = ""
+			}
+			continue
+		}
+
+		if depth > 0 {
+			depth += strings.Count(line, "[") - strings.Count(line, "]")
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var found toml.Key
+		if strings.HasPrefix(line, "[") {
+			name := strings.Trim(strings.SplitN(line, "#", 2)[0], "[] \t")
+			table = splitKey(name)
+			found = table
+		} else if idx := strings.Index(line, "="); idx > 0 {
+			found = append(append(toml.Key{}, table...), splitKey(line[:idx])...)
+			value := strings.TrimSpace(line[idx+1:])
+			for _, delim := range []string{`"""`, `'''`} {
+				if strings.HasPrefix(value, delim) && !strings.Contains(value[3:], delim) {
+					closer = delim
+				}
+			}
+			if strings.HasPrefix(value, "[") {
+				depth = strings.Count(value, "[") - strings.Count(value, "]")
+			}
+		} else {
+			continue
+		}
+
+		if n := matchLen(found, key); n > bestLen {
+			best, bestLen = i+1, n
+			if n == len(key) {
+				break
+			}
+		}
+	}
+
+	return best
+}
+
+// splitKey splits a dotted TOML key into its parts, removing any quotes.
+func splitKey(name string) toml.Key {
+	parts := toml.Key{}
+	for _, part := range strings.Split(name, ".") {
+		parts = append(parts, strings.Trim(strings.TrimSpace(part), `"'`))
+	}
+	return parts
+}
+
+// matchLen returns len(found) if found is a prefix of key, otherwise 0.
+func matchLen(found, key toml.Key) int {
+	if len(found) > len(key) {
+		return 0
+	}
+	for i := range found {
+		if found[i] != key[i] {
+			return 0
+		}
+	}
+	return len(found)
+}
@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// sshAgentDir is where the SSH agent sockets are found in containers.
+const sshAgentDir = "/run/wharfrat/ssh-agent"
+
+// desktopAgent is the SSH agent socket that Docker Desktop provides to
+// containers, since sockets from the macOS host can't be mounted.
+const desktopAgent = "/run/host-services/ssh-auth.sock"
+
+// crateHostDir returns the host directory that is mounted into the crate's
+// container to share the kind of host resource (e.g. ssh-agent). It is named
+// after a hash of the container name, to keep socket paths short.
+func crateHostDir(kind string, crate *config.Crate) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(crate.ContainerName()))
+	dir := filepath.Join(cache, "wharfrat", kind, hex.EncodeToString(sum[:6]))
+
+	// Create the directory now, otherwise docker creates it owned by root
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// sshAgentBind returns the bind mount that gives the crate's container access
+// to the SSH agent.
+func sshAgentBind(crate *config.Crate) (string, error) {
+	if runtime.GOOS == "darwin" {
+		return desktopAgent + ":" + desktopAgent, nil
+	}
+
+	dir, err := crateHostDir("ssh-agent", crate)
+	if err != nil {
+		return "", err
+	}
+
+	return dir + ":" + sshAgentDir, nil
+}
+
+// sshAgentLink is the name of the link to the socket of the newest wharfrat
+// session in the SSH agent directory.
+const sshAgentLink = "agent.sock"
+
+// sshAgentSocket returns the path of the SSH agent socket in the crate's
+// container, or an empty string if there isn't one. The host agent socket
+// can't be mounted directly, since it changes with each login and may not be
+// in a mounted directory, so instead a socket is created in the mounted
+// directory that forwards connections to the agent for as long as wharfrat is
+// running, and SSH_AUTH_SOCK points at a link that follows the newest one.
+func (c *Connection) sshAgentSocket(crate *config.Crate) (string, error) {
+	if !crate.SSHAgent {
+		return "", nil
+	}
+
+	if runtime.GOOS == "darwin" {
+		return desktopAgent, nil
+	}
+
+	if c.sshAgent != "" {
+		return c.sshAgent, nil
+	}
+
+	hostSocket := os.Getenv("SSH_AUTH_SOCK")
+	if hostSocket == "" {
+		log.Printf("SSH AGENT: SSH_AUTH_SOCK not set")
+		return "", nil
+	}
+
+	dir, err := crateHostDir("ssh-agent", crate)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.startAgentProxy(dir, sshAgentLink, hostSocket); err != nil {
+		return "", fmt.Errorf("failed to create SSH agent socket: %w", err)
+	}
+
+	c.sshAgent = sshAgentDir + "/" + sshAgentLink
+
+	return c.sshAgent, nil
+}
+
+// startAgentProxy creates a socket in dir that forwards connections to target
+// for as long as wharfrat is running, and points the link in dir at it. Each
+// session has its own socket, and the link is handed over to the newest of
+// the others when the session ends.
+func (c *Connection) startAgentProxy(dir, link, target string) error {
+	removeStaleSockets(filepath.Join(dir, "agent.*.sock"))
+
+	name := fmt.Sprintf("agent.%d.sock", os.Getpid())
+	if err := c.forwardSocket(filepath.Join(dir, name), target); err != nil {
+		return err
+	}
+
+	c.agentLinks = append(c.agentLinks, filepath.Join(dir, link))
+
+	return linkAgent(filepath.Join(dir, link), name)
+}
+
+// linkAgent points link at the socket called name in the same directory, or
+// at the newest socket of the other running sessions if name is empty.
+func linkAgent(link, name string) error {
+	dir := filepath.Dir(link)
+
+	if name == "" {
+		removeStaleSockets(filepath.Join(dir, "agent.*.sock"))
+		matches, _ := filepath.Glob(filepath.Join(dir, "agent.*.sock"))
+		var newest time.Time
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err == nil && info.ModTime().After(newest) {
+				newest = info.ModTime()
+				name = filepath.Base(match)
+			}
+		}
+		if name == "" {
+			os.Remove(link)
+			return nil
+		}
+	}
+
+	// Replace the link atomically, so that it is never missing
+	tmp := filepath.Join(dir, fmt.Sprintf(".link.%d", os.Getpid()))
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, link)
+}
+
+// forwardSocket creates a socket at path that forwards connections to target,
+// until the connection is closed.
+func (c *Connection) forwardSocket(path, target string) error {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("AGENT: forwarding %s to %s", path, target)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("AGENT: accept failed: %s", err)
+				return
+			}
+			go forwardConn(conn, target)
+		}
+	}()
+
+	c.listeners = append(c.listeners, listener)
+
+	return nil
+}
+
+// forwardConn copies data between a connection from the container and the
+// host agent.
+func forwardConn(conn net.Conn, target string) {
+	defer conn.Close()
+
+	agent, err := net.Dial("unix", target)
+	if err != nil {
+		log.Printf("AGENT: failed to connect to %s: %s", target, err)
+		return
+	}
+	defer agent.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(agent, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, agent)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// removeStaleSockets removes sockets matching pattern that were left behind
+// by wharfrat processes that didn't exit cleanly.
+func removeStaleSockets(pattern string) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		conn, err := net.Dial("unix", match)
+		if err == nil {
+			conn.Close()
+			continue
+		}
+		log.Printf("AGENT: removing stale socket %s", match)
+		os.Remove(match)
+	}
+}
@@ -0,0 +1,146 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker/label"
+)
+
+// ensureImage builds the image for crates that have a build setting, unless an
+// image built from the same context and settings already exists.
+func (c *Connection) ensureImage(crate *config.Crate) error {
+	if crate.Build == nil {
+		return nil
+	}
+
+	image, err := c.BuiltImage(crate)
+	if err != nil {
+		return err
+	}
+
+	if image != nil {
+		log.Printf("BUILD: reusing %s (%s)", crate.Image, image.ID)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Building image '%s'\n", crate.Image)
+
+	if err := c.buildImage(crate); err != nil {
+		return fmt.Errorf("failed to build image: %w", err)
+	}
+
+	c.removeOldBuilds(crate)
+
+	// Give crate another go at setting defaults now we have the image
+	return crate.SetDefaults(c)
+}
+
+// BuiltImage sets the crate's image to the tag that its build produces, and
+// returns the image if it has already been built.
+func (c *Connection) BuiltImage(crate *config.Crate) (*image.InspectResponse, error) {
+	if err := crate.SetBuildImage(); err != nil {
+		return nil, err
+	}
+
+	image, err := c.GetImage(crate.Image)
+	if err != nil {
+		return nil, err
+	}
+
+	if image != nil {
+		// Give crate another go at setting defaults now we have the image
+		if err := crate.SetDefaults(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return image, nil
+}
+
+// removeOldBuilds removes the images that were built for the crate before the
+// current one. Images that are still used by containers are left alone.
+func (c *Connection) removeOldBuilds(crate *config.Crate) {
+	args := filters.NewArgs(
+		filters.Arg("reference", crate.BuildRepository()),
+		filters.Arg("label", label.Project+"="+crate.ProjectPath()),
+		filters.Arg("label", label.Crate+"="+crate.Name()),
+	)
+
+	images, err := c.c.ImageList(c.ctx, image.ListOptions{Filters: args})
+	if err != nil {
+		log.Printf("BUILD: failed to list old images: %s", err)
+		return
+	}
+
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag == crate.Image {
+				continue
+			}
+			log.Printf("BUILD: removing old image %s", tag)
+			if _, err := c.c.ImageRemove(c.ctx, tag, image.RemoveOptions{PruneChildren: true}); err != nil {
+				log.Printf("BUILD: failed to remove %s: %s", tag, err)
+			}
+		}
+	}
+}
+
+func (c *Connection) buildImage(crate *config.Crate) error {
+	authConfigs := map[string]registry.AuthConfig{}
+	if creds, err := LoadCredentials(); err != nil {
+		log.Printf("Failed to load saved auth: %s", err)
+	} else if authConfigs, err = creds.All(); err != nil {
+		log.Printf("Failed to get saved auth: %s", err)
+		authConfigs = map[string]registry.AuthConfig{}
+	}
+
+	args := map[string]*string{}
+	for name, value := range crate.Build.Args {
+		value := value
+		args[name] = &value
+	}
+
+	options := build.ImageBuildOptions{
+		Tags:        []string{crate.Image},
+		Dockerfile:  filepath.ToSlash(crate.BuildDockerfile()),
+		Target:      crate.Build.Target,
+		BuildArgs:   args,
+		AuthConfigs: authConfigs,
+		Remove:      true,
+		Platform:    crate.Platform,
+		Labels: map[string]string{
+			label.Project: crate.ProjectPath(),
+			label.Crate:   crate.Name(),
+		},
+	}
+
+	log.Printf("BUILD: image:%s context:%s", crate.Image, crate.BuildDir())
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(crate.WriteBuildContext(w))
+	}()
+	defer r.Close()
+
+	resp, err := c.c.ImageBuild(c.ctx, r, options)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fd, term := term.GetFdInfo(os.Stderr)
+
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stderr, fd, term, nil)
+}
@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"log"
+	"os/user"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+
+	"wharfr.at/wharfrat/lib/config"
+	"wharfr.at/wharfrat/lib/docker/label"
+)
+
+// ensureCaches creates the volumes for the crate's caches, and returns the
+// binds needed to mount them into the container.
+func (c *Connection) ensureCaches(crate *config.Crate) ([]string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	binds := make([]string, 0, len(crate.Caches))
+	for _, path := range crate.Caches {
+		name := crate.CacheVolume(path)
+
+		// Creating a volume that already exists is a no-op, so caches from
+		// previous containers will be reused
+		vol, err := c.c.VolumeCreate(c.ctx, volume.CreateOptions{
+			Name: name,
+			Labels: map[string]string{
+				label.Project: crate.ProjectPath(),
+				label.Crate:   crate.Name(),
+				label.User:    usr.Username,
+				label.Cache:   path,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("CACHE: %s -> %s", vol.Name, path)
+
+		binds = append(binds, vol.Name+":"+path)
+	}
+
+	return binds, nil
+}
+
+// ListCaches returns the cache volumes that belong to the current user.
+func (c *Connection) ListCaches() ([]*volume.Volume, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.VolumeList(c.ctx, volume.ListOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("label", label.Cache),
+			filters.Arg("label", label.User+"="+usr.Username),
+		),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Volumes, nil
+}
+
+// RemoveCache removes a cache volume. This will fail if the volume is still
+// used by a container.
+func (c *Connection) RemoveCache(name string) error {
+	return c.c.VolumeRemove(c.ctx, name, false)
+}
@@ -18,8 +18,6 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
 
-	specs "github.com/opencontainers/image-spec/specs-go/v1"
-
 	"wharfr.at/wharfrat/lib/config"
 	"wharfr.at/wharfrat/lib/docker/label"
 	"wharfr.at/wharfrat/lib/self"
@@ -40,8 +38,8 @@ func exists(path string) bool {
 	return err == nil
 }
 
-func getSelf() (*bytes.Buffer, error) {
-	selfData, err := self.GetLinux()
+func getSelf(arch string) (*bytes.Buffer, error) {
+	selfData, err := self.GetLinux(arch)
 	if err != nil {
 		return nil, err
 	}
@@ -58,11 +56,12 @@ func getSelf() (*bytes.Buffer, error) {
 		ModTime:  time.Now(),
 	}
 
+	// The binary is only copied in once, as it is large
 	wharfratHdr := &tar.Header{
-		Typeflag: tar.TypeReg,
+		Typeflag: tar.TypeLink,
 		Name:     "/usr/bin/wharfrat",
-		Size:     int64(len(selfData)),
-		Mode:     int64(0755),
+		Linkname: "/sbin/wr-init",
+		Mode:     int64(os.ModeSetuid | os.ModeSetgid | 0755),
 		Uid:      0,
 		Gid:      0,
 		Uname:    "root",
@@ -97,9 +96,6 @@ func getSelf() (*bytes.Buffer, error) {
 	if err := w.WriteHeader(wharfratHdr); err != nil {
 		return nil, fmt.Errorf("failed to build self archive (wharfrat header): %w", err)
 	}
-	if _, err := w.Write(selfData); err != nil {
-		return nil, fmt.Errorf("failed to build self archive (wharfrat data): %w", err)
-	}
 
 	if err := w.WriteHeader(wrHdr); err != nil {
 		return nil, fmt.Errorf("failed to build self archive (wr header): %w", err)
@@ -109,13 +105,20 @@ func getSelf() (*bytes.Buffer, error) {
 }
 
 func (c *Connection) Create(crate *config.Crate) (string, error) {
-	// self, err := os.Readlink("/proc/self/exe")
-	// if err != nil {
-	// 	return "", fmt.Errorf("Failed to get self: %s", err)
-	// }
-	selfTar, err := getSelf()
-	if err != nil {
-		return "", fmt.Errorf("failed to get self: %w", err)
+	if err := crate.CheckTrust(c); err != nil {
+		return "", err
+	}
+
+	if err := crate.CheckPolicy(); err != nil {
+		return "", err
+	}
+
+	if err := c.ensureImage(crate); err != nil {
+		return "", err
+	}
+
+	if err := c.ensurePulled(crate); err != nil {
+		return "", err
 	}
 
 	usr, err := user.Current()
@@ -166,9 +169,11 @@ func (c *Connection) Create(crate *config.Crate) (string, error) {
 
 	binds := []string{}
 
-	if exists("/tmp/.X11-unix") {
-		binds = append(binds, "/tmp/.X11-unix:/tmp/.X11-unix")
+	gui, err := guiBinds(crate, false)
+	if err != nil {
+		return "", err
 	}
+	binds = append(binds, gui...)
 
 	if crate.MountHome {
 		binds = append(binds, self.HomeMount...)
@@ -180,13 +185,53 @@ func (c *Connection) Create(crate *config.Crate) (string, error) {
 	}
 
 	if crate.Volumes != nil {
-		for _, volume := range crate.Volumes {
-			binds = append(binds, os.Expand(volume, crate.Getenv))
+		binds = append(binds, crate.Volumes...)
+	}
+
+	if crate.SSHAgent {
+		bind, err := sshAgentBind(crate)
+		if err != nil {
+			return "", fmt.Errorf("failed to set up SSH agent: %w", err)
 		}
+		binds = append(binds, bind)
 	}
 
+	if crate.GPGAgent {
+		if runtime.GOOS == "darwin" {
+			fmt.Fprintf(os.Stderr, "Warning: gpg-agent is not supported on macOS\n")
+		} else {
+			bind, err := gpgAgentBind(crate)
+			if err != nil {
+				return "", fmt.Errorf("failed to set up GPG agent: %w", err)
+			}
+			binds = append(binds, bind)
+		}
+	}
+
+	caches, err := c.ensureCaches(crate)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache volumes: %w", err)
+	}
+	binds = append(binds, caches...)
+
 	log.Printf("BINDS: %v", binds)
 
+	resources, shmSize, err := crate.Resources()
+	if err != nil {
+		return "", err
+	}
+
+	resources.Devices, err = crate.DeviceMappings()
+	if err != nil {
+		return "", err
+	}
+	resources.DeviceCgroupRules = crate.DeviceRules
+
+	securityOpt, err := crate.SecurityOpts()
+	if err != nil {
+		return "", err
+	}
+
 	// apparently we shouldn't let the DNS... fields be nil?
 	// See https://github.com/docker/docker/pull/17779
 	hostConfig := &container.HostConfig{
@@ -199,14 +244,18 @@ func (c *Connection) Create(crate *config.Crate) (string, error) {
 		DNSSearch:    []string{},
 		DNSOptions:   []string{},
 		NetworkMode:  container.NetworkMode(crate.Network),
+		Resources:    resources,
+		ShmSize:      shmSize,
+		Privileged:   crate.Privileged,
+		SecurityOpt:  securityOpt,
+		UsernsMode:   c.c.UsernsMode(),
 	}
 
 	networkingConfig := &network.NetworkingConfig{}
 
-	// TODO: hard code the platform for now ...
-	platform := &specs.Platform{
-		Architecture: runtime.GOARCH,
-		OS:           "linux",
+	platform, err := crate.ImagePlatform()
+	if err != nil {
+		return "", err
 	}
 
 	var namedRef reference.Named
@@ -225,7 +274,7 @@ func (c *Connection) Create(crate *config.Crate) (string, error) {
 	if errdefs.IsNotFound(err) && namedRef != nil {
 		fmt.Fprintf(os.Stderr, "Unable to find image '%s' locally\n", reference.FamiliarString(namedRef))
 
-		if err := c.pullImage(config.Image); err != nil {
+		if err := c.pullImage(config.Image, crate.Platform); err != nil {
 			return "", err
 		}
 
@@ -248,28 +297,86 @@ func (c *Connection) Create(crate *config.Crate) (string, error) {
 
 	log.Printf("CREATE COMPLETE: %s", cid)
 
-	if err := c.c.CopyToContainer(c.ctx, cid, "/", selfTar, container.CopyToContainerOptions{}); err != nil {
+	if err := c.startAndSetup(cid, crate); err != nil {
 		return "", err
 	}
 
+	if crate.ReadOnly {
+		cid, err = c.recreateReadOnly(cid, config, hostConfig, networkingConfig, platform, crate.ContainerName())
+		if err != nil {
+			_ = c.EnsureRemoved(crate.ContainerName())
+			return "", err
+		}
+	}
+
+	c.notifyCreated(cid, crate)
+
+	log.Printf("CREATE COMPLETE: %s", cid)
+
+	return cid, nil
+}
+
+// startAndSetup copies wharfrat into a newly created container, starts it and
+// runs the setup. If the setup fails, then the container is removed.
+func (c *Connection) startAndSetup(cid string, crate *config.Crate) error {
+	// self, err := os.Readlink("/proc/self/exe")
+	// if err != nil {
+	// 	return "", fmt.Errorf("Failed to get self: %s", err)
+	// }
+	arch, err := c.imageArch(cid)
+	if err != nil {
+		return err
+	}
+
+	selfTar, err := getSelf(arch)
+	if err != nil {
+		return fmt.Errorf("failed to get self: %w", err)
+	}
+
+	if err := c.c.CopyToContainer(c.ctx, cid, "/", selfTar, container.CopyToContainerOptions{}); err != nil {
+		return err
+	}
+
 	log.Printf("SELF COPIED: %s", cid)
 
 	if err := c.c.ContainerStart(c.ctx, cid, container.StartOptions{}); err != nil {
-		return "", err
+		return err
 	}
 
 	log.Printf("STARTED: %s", cid)
 
 	if err := c.setup(cid, crate); err != nil {
 		_ = c.EnsureRemoved(crate.ContainerName())
+		return err
+	}
+
+	return nil
+}
+
+// imageArch returns the architecture of the image that the container was
+// created from, which decides the wr-init binary to copy in.
+func (c *Connection) imageArch(cid string) (string, error) {
+	info, err := c.c.ContainerInspect(c.ctx, cid)
+	if err != nil {
 		return "", err
 	}
 
-	for _, f := range created {
-		f(c, cid, crate)
+	image, err := c.c.ImageInspect(c.ctx, info.Image)
+	if err != nil {
+		return "", err
 	}
 
-	log.Printf("CREATE COMPLETE: %s", cid)
+	log.Printf("IMAGE PLATFORM: %s/%s", image.Os, image.Architecture)
 
-	return cid, nil
+	if image.Architecture == "" {
+		return "", fmt.Errorf("unable to determine architecture of image %s", info.Config.Image)
+	}
+
+	return image.Architecture, nil
+}
+
+func (c *Connection) notifyCreated(cid string, crate *config.Crate) {
+	for _, f := range created {
+		f(c, cid, crate)
+	}
 }
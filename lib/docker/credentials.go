@@ -0,0 +1,508 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+	dockerregistry "github.com/docker/docker/registry"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// tokenUsername is the username credential helpers use for identity tokens.
+const tokenUsername = "<token>"
+
+// errCredentialsNotFound is returned by runHelper when the helper doesn't have
+// credentials for the server.
+var errCredentialsNotFound = errors.New("credentials not found")
+
+// Credentials are the registry credentials from the docker CLI config, which
+// are either kept in the config file itself, or in credential helpers
+// (docker-credential-* binaries) named by credsStore and credHelpers.
+type Credentials struct {
+	path        string
+	raw         map[string]json.RawMessage
+	auths       map[string]authEntry
+	credsStore  string
+	credHelpers map[string]string
+}
+
+// Credential describes the saved credentials for a registry.
+type Credential struct {
+	Server   string
+	Username string
+	Store    string
+}
+
+// authEntry is an entry in the auths section of the docker CLI config.
+type authEntry struct {
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// helperCredentials is the message used by the credential helper protocol.
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// credentialKey returns the key that the docker CLI saves credentials for
+// server under, which is the index server URL for Docker Hub, and the
+// hostname for other registries.
+func credentialKey(server string) string {
+	host := dockerregistry.ConvertToHostname(server)
+	switch host {
+	case "docker.io", dockerregistry.IndexHostname, "registry-1.docker.io":
+		return dockerregistry.IndexServer
+	}
+	return host
+}
+
+// LoadCredentials loads the docker CLI credentials.
+func LoadCredentials() (*Credentials, error) {
+	creds := &Credentials{
+		path:        filepath.Join(dockerConfigDir(), "config.json"),
+		raw:         map[string]json.RawMessage{},
+		auths:       map[string]authEntry{},
+		credHelpers: map[string]string{},
+	}
+
+	data, err := os.ReadFile(creds.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read docker config: %w", err)
+	} else if err == nil {
+		file := struct {
+			Auths       map[string]authEntry `json:"auths"`
+			CredsStore  string               `json:"credsStore"`
+			CredHelpers map[string]string    `json:"credHelpers"`
+		}{}
+		if err := json.Unmarshal(data, &creds.raw); err != nil {
+			return nil, fmt.Errorf("failed to parse docker config: %w", err)
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse docker config: %w", err)
+		}
+		for key, entry := range file.Auths {
+			creds.auths[key] = entry
+		}
+		for key, helper := range file.CredHelpers {
+			creds.credHelpers[key] = helper
+		}
+		creds.credsStore = file.CredsStore
+	}
+
+	return creds, nil
+}
+
+// Migrate moves credentials from the old wharfrat auth.json file into the
+// docker credential store. Entries for registries that already have docker
+// credentials are left in place.
+func (c *Credentials) Migrate() {
+	legacy, err := config.LoadAuth()
+	if err != nil {
+		log.Printf("Failed to load saved auth: %s", err)
+		return
+	}
+	if len(legacy) == 0 {
+		return
+	}
+
+	moved := false
+	for addr, encoded := range legacy {
+		authConfig, err := registry.DecodeAuthConfig(encoded)
+		if err != nil {
+			log.Printf("Failed to decode saved auth for %s: %s", addr, err)
+			continue
+		}
+		if authConfig.ServerAddress == "" {
+			authConfig.ServerAddress = addr
+		}
+
+		existing, err := c.Get(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to move credentials for %s: %s\n", addr, err)
+			continue
+		}
+
+		if existing != nil {
+			fmt.Fprintf(os.Stderr, "Not moving credentials for %s from %s, %s already has credentials\n", addr, config.AuthPath(), c.storeName(credentialKey(addr)))
+			continue
+		}
+
+		if err := c.Store(authConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to move credentials for %s: %s\n", addr, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Moved credentials for %s from %s to %s\n", addr, config.AuthPath(), c.storeName(credentialKey(addr)))
+
+		legacy.Clear(addr)
+		moved = true
+	}
+
+	if !moved {
+		return
+	}
+
+	if err := legacy.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update %s: %s\n", config.AuthPath(), err)
+	}
+}
+
+// helper returns the name of the credential helper for key, or an empty
+// string if credentials are kept in the config file.
+func (c *Credentials) helper(key string) string {
+	if helper, found := c.credHelpers[key]; found {
+		return helper
+	}
+	if helper, found := c.credHelpers[dockerregistry.ConvertToHostname(key)]; found {
+		return helper
+	}
+	return c.credsStore
+}
+
+// storeName describes where the credentials for key are kept.
+func (c *Credentials) storeName(key string) string {
+	if helper := c.helper(key); helper != "" {
+		return "docker-credential-" + helper
+	}
+	return c.path
+}
+
+// Get returns the saved credentials for server, or nil if there are none.
+func (c *Credentials) Get(server string) (*registry.AuthConfig, error) {
+	key := credentialKey(server)
+
+	if helper := c.helper(key); helper != "" {
+		out, err := runHelper(helper, "get", key)
+		if errors.Is(err, errCredentialsNotFound) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		creds := helperCredentials{}
+		if err := json.Unmarshal(out, &creds); err != nil {
+			return nil, fmt.Errorf("invalid response from docker-credential-%s: %w", helper, err)
+		}
+
+		authConfig := &registry.AuthConfig{ServerAddress: key}
+		if creds.Username == tokenUsername {
+			authConfig.IdentityToken = creds.Secret
+		} else {
+			authConfig.Username = creds.Username
+			authConfig.Password = creds.Secret
+		}
+		return authConfig, nil
+	}
+
+	entry, found := c.auths[key]
+	if !found {
+		for addr, e := range c.auths {
+			if credentialKey(addr) == key {
+				entry, found = e, true
+				break
+			}
+		}
+	}
+	if !found || (entry.Auth == "" && entry.IdentityToken == "") {
+		return nil, nil
+	}
+
+	authConfig := &registry.AuthConfig{
+		ServerAddress: key,
+		IdentityToken: entry.IdentityToken,
+	}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for %s in %s: %w", key, c.path, err)
+		}
+		user, pass, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid auth for %s in %s", key, c.path)
+		}
+		authConfig.Username = user
+		authConfig.Password = pass
+	}
+
+	return authConfig, nil
+}
+
+// Encoded returns the saved credentials for server encoded for the docker API,
+// or an empty string if there are none. Credentials that are still in the old
+// wharfrat auth.json file are used if docker has none.
+func (c *Credentials) Encoded(server string) (string, error) {
+	authConfig, err := c.Get(server)
+	if err != nil {
+		return "", err
+	}
+	if authConfig == nil {
+		legacy, found := legacyCredentials()[credentialKey(server)]
+		if !found {
+			return "", nil
+		}
+		authConfig = &legacy
+	}
+	return registry.EncodeAuthConfig(*authConfig)
+}
+
+// legacyCredentials returns the credentials from the old wharfrat auth.json
+// file, keyed by credential key, without moving them.
+func legacyCredentials() map[string]registry.AuthConfig {
+	legacy, err := config.LoadAuth()
+	if err != nil {
+		log.Printf("Failed to load saved auth: %s", err)
+		return nil
+	}
+
+	all := map[string]registry.AuthConfig{}
+	for addr, encoded := range legacy {
+		authConfig, err := registry.DecodeAuthConfig(encoded)
+		if err != nil {
+			log.Printf("Failed to decode saved auth for %s: %s", addr, err)
+			continue
+		}
+		authConfig.ServerAddress = credentialKey(addr)
+		all[authConfig.ServerAddress] = *authConfig
+	}
+	return all
+}
+
+// All returns all the saved credentials, keyed by server, including any that
+// are still in the old wharfrat auth.json file.
+func (c *Credentials) All() (map[string]registry.AuthConfig, error) {
+	list, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string]registry.AuthConfig{}
+	for _, cred := range list {
+		authConfig, err := c.Get(cred.Server)
+		if err != nil {
+			log.Printf("Failed to get credentials for %s: %s", cred.Server, err)
+			continue
+		}
+		if authConfig != nil {
+			all[cred.Server] = *authConfig
+		}
+	}
+
+	for key, authConfig := range legacyCredentials() {
+		if _, found := all[key]; !found {
+			all[key] = authConfig
+		}
+	}
+
+	return all, nil
+}
+
+// List returns the registries that have saved credentials, sorted by server.
+func (c *Credentials) List() ([]Credential, error) {
+	found := map[string]Credential{}
+
+	if c.credsStore != "" {
+		out, err := runHelper(c.credsStore, "list", "")
+		if err != nil {
+			return nil, err
+		}
+		users := map[string]string{}
+		if err := json.Unmarshal(out, &users); err != nil {
+			return nil, fmt.Errorf("invalid response from docker-credential-%s: %w", c.credsStore, err)
+		}
+		for server, user := range users {
+			key := credentialKey(server)
+			if c.helper(key) != c.credsStore {
+				continue
+			}
+			found[key] = Credential{Server: key, Username: user, Store: c.storeName(key)}
+		}
+	}
+
+	for server := range c.credHelpers {
+		key := credentialKey(server)
+		authConfig, err := c.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if authConfig != nil {
+			found[key] = Credential{Server: key, Username: authConfig.Username, Store: c.storeName(key)}
+		}
+	}
+
+	for server := range c.auths {
+		key := credentialKey(server)
+		if _, ok := found[key]; ok || c.helper(key) != "" {
+			continue
+		}
+		authConfig, err := c.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if authConfig != nil {
+			found[key] = Credential{Server: key, Username: authConfig.Username, Store: c.path}
+		}
+	}
+
+	list := make([]Credential, 0, len(found))
+	for _, cred := range found {
+		list = append(list, cred)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Server < list[j].Server
+	})
+
+	return list, nil
+}
+
+// Store saves the credentials, using a credential helper if one is
+// configured for the server.
+func (c *Credentials) Store(authConfig *registry.AuthConfig) error {
+	key := credentialKey(authConfig.ServerAddress)
+
+	if helper := c.helper(key); helper != "" {
+		creds := helperCredentials{
+			ServerURL: key,
+			Username:  authConfig.Username,
+			Secret:    authConfig.Password,
+		}
+		if authConfig.IdentityToken != "" {
+			creds.Username = tokenUsername
+			creds.Secret = authConfig.IdentityToken
+		}
+		input, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		if _, err := runHelper(helper, "store", string(input)); err != nil {
+			return err
+		}
+
+		// The docker CLI leaves an empty entry, so that the registry shows
+		// up in the config
+		c.auths[key] = authEntry{}
+		return c.save()
+	}
+
+	entry := authEntry{IdentityToken: authConfig.IdentityToken}
+	if authConfig.IdentityToken == "" {
+		entry.Auth = base64.StdEncoding.EncodeToString([]byte(authConfig.Username + ":" + authConfig.Password))
+	}
+	c.auths[key] = entry
+
+	return c.save()
+}
+
+// Erase removes the saved credentials for server, returning false if there
+// weren't any.
+func (c *Credentials) Erase(server string) (bool, error) {
+	key := credentialKey(server)
+
+	existing, err := c.Get(key)
+	if err != nil {
+		return false, err
+	}
+
+	if helper := c.helper(key); helper != "" && existing != nil {
+		if _, err := runHelper(helper, "erase", key); err != nil && !errors.Is(err, errCredentialsNotFound) {
+			return false, err
+		}
+	}
+
+	removed := false
+	for addr := range c.auths {
+		if credentialKey(addr) == key {
+			delete(c.auths, addr)
+			removed = true
+		}
+	}
+	if removed {
+		if err := c.save(); err != nil {
+			return false, err
+		}
+	}
+
+	return existing != nil, nil
+}
+
+// save writes the auths back to the docker CLI config, leaving everything
+// else in the file alone.
+func (c *Credentials) save() error {
+	auths, err := json.Marshal(c.auths)
+	if err != nil {
+		return err
+	}
+	c.raw["auths"] = auths
+
+	data, err := json.MarshalIndent(c.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// runHelper runs a docker credential helper, passing it input on stdin.
+func runHelper(helper, action, input string) ([]byte, error) {
+	name := "docker-credential-" + helper
+
+	log.Printf("CREDENTIAL HELPER: %s %s", name, action)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.Command(name, action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		// Helpers report errors on stdout
+		msg := strings.TrimSpace(stdout.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stderr.String())
+		}
+		if strings.Contains(msg, "credentials not found") {
+			return nil, errCredentialsNotFound
+		}
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", name, action, msg)
+	}
+
+	return stdout.Bytes(), nil
+}
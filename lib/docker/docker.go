@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -16,44 +17,56 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/registry"
 	"github.com/docker/docker/api/types/system"
-	"github.com/docker/docker/client"
 
 	"wharfr.at/wharfrat/lib/config"
 	"wharfr.at/wharfrat/lib/docker/label"
 )
 
 type Connection struct {
-	c   *client.Client
-	ctx context.Context
+	c          Runtime
+	ctx        context.Context
+	pulled     map[string]bool
+	sshAgent   string
+	gpgAgent   bool
+	agentLinks []string
+	listeners  []net.Listener
 }
 
 func Connect() (*Connection, error) {
-	host := config.Local().DockerURL
-
-	opts := []client.Opt{}
-	if host != "" {
-		opts = append(opts, client.WithHost(host))
-	}
+	ctx := context.Background()
 
-	c, err := client.NewClientWithOpts(opts...)
+	c, err := newRuntime(ctx, config.Local())
 	if err != nil {
 		return nil, err
 	}
 
-	ctx := context.Background()
-	before := c.ClientVersion()
-	c.NegotiateAPIVersion(ctx)
-	after := c.ClientVersion()
-
-	log.Printf("API: before: %s, after: %s", before, after)
-
 	return &Connection{
-		c:   c,
-		ctx: ctx,
+		c:      c,
+		ctx:    ctx,
+		pulled: map[string]bool{},
 	}, nil
 }
 
+// RuntimeName returns the name of the container runtime in use.
+func (c *Connection) RuntimeName() string {
+	return c.c.Name()
+}
+
+// Endpoint describes where the container runtime is being reached.
+func (c *Connection) Endpoint() string {
+	return c.c.Endpoint()
+}
+
 func (c *Connection) Close() error {
+	for _, listener := range c.listeners {
+		listener.Close()
+	}
+	for _, link := range c.agentLinks {
+		// Hand the agent link over to another session, if there is one
+		if err := linkAgent(link, ""); err != nil {
+			log.Printf("AGENT: failed to update link %s: %s", link, err)
+		}
+	}
 	return c.c.Close()
 }
 
@@ -113,9 +126,22 @@ func (c *Connection) Stop(id string) error {
 }
 
 func (c *Connection) Remove(id string, force bool) error {
-	return c.c.ContainerRemove(c.ctx, id, container.RemoveOptions{
+	info, err := c.c.ContainerInspect(c.ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := c.c.ContainerRemove(c.ctx, id, container.RemoveOptions{
 		Force: force,
-	})
+	}); err != nil {
+		return err
+	}
+
+	if info.Config != nil {
+		c.removeSetupImage(info.Config.Labels)
+	}
+
+	return nil
 }
 
 func (c *Connection) calcWorkdir(id, user, workdir string, crate *config.Crate) (string, error) {
@@ -169,22 +195,27 @@ func (c *Connection) calcWorkdirSingle(id, user, workdir string, crate *config.C
 		if idx := strings.Index(user, ":"); idx >= 0 {
 			user = user[:idx]
 		}
-		cmd := []string{"/sbin/wr-init", "homedir", user}
-		stdout := &bytes.Buffer{}
-		stderr := &bytes.Buffer{}
-		exit, err := c.run(id, cmd, nil, nil, stdout, stderr)
-		if err != nil {
-			return "", err
-		}
-		if exit != 0 {
-			return "", fmt.Errorf("failed to get home directory for %s: %s", user, stderr.String())
-		}
-		return string(bytes.TrimSpace(stdout.Bytes())), nil
+		return c.homedir(id, user)
 	}
 
 	return "", fmt.Errorf("invalid working-dir: '%s'", workdir)
 }
 
+// homedir returns the home directory of user in the container.
+func (c *Connection) homedir(id, user string) (string, error) {
+	cmd := []string{"/sbin/wr-init", "homedir", user}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	exit, err := c.run(id, cmd, nil, nil, stdout, stderr)
+	if err != nil {
+		return "", err
+	}
+	if exit != 0 {
+		return "", fmt.Errorf("failed to get home directory for %s: %s", user, stderr.String())
+	}
+	return string(bytes.TrimSpace(stdout.Bytes())), nil
+}
+
 func (c *Connection) Login(addr, user, pass string) (*registry.AuthConfig, error) {
 	authConfig := registry.AuthConfig{
 		ServerAddress: addr,
@@ -199,6 +230,13 @@ func (c *Connection) Login(addr, user, pass string) (*registry.AuthConfig, error
 
 	log.Printf("LOGIN: token=%s, status=%s", resp.IdentityToken, resp.Status)
 
+	if resp.IdentityToken != "" {
+		// The token replaces the password, which then doesn't need to be
+		// saved
+		authConfig.Password = ""
+		authConfig.IdentityToken = resp.IdentityToken
+	}
+
 	if resp.Status != "" {
 		fmt.Println(resp.Status)
 	}
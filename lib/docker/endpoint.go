@@ -0,0 +1,205 @@
+package docker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// Context is the name of the Docker context to use, which overrides the
+// environment and Docker config when set (i.e. by the --context option).
+var Context string
+
+// defaultContext is the name the docker CLI uses for the endpoint given by
+// DOCKER_HOST, or the default socket.
+const defaultContext = "default"
+
+// Endpoint is the Docker daemon to connect to, resolved in the same way as the
+// docker CLI.
+type Endpoint struct {
+	Host          string
+	Context       string
+	Source        string
+	TLSDir        string
+	SkipTLSVerify bool
+	fromEnv       bool
+}
+
+// String describes the endpoint and where it came from, e.g. for showing in
+// wharfrat info.
+func (e *Endpoint) String() string {
+	host := e.Host
+	if host == "" {
+		host = client.DefaultDockerHost
+	}
+	if e.Context != "" && e.Context != defaultContext {
+		return fmt.Sprintf("%s (context %s, from %s)", host, e.Context, e.Source)
+	}
+	return fmt.Sprintf("%s (from %s)", host, e.Source)
+}
+
+// contextMeta is the part of a docker context's meta.json that wharfrat uses.
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// dockerConfigDir returns the docker CLI config directory.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Printf("Failed to get home directory: %s", err)
+		return ".docker"
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the context selected with docker context use.
+func currentContext() string {
+	data, err := os.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read docker config: %s", err)
+		}
+		return ""
+	}
+
+	cfg := struct {
+		CurrentContext string `json:"currentContext"`
+	}{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		log.Printf("Failed to parse docker config: %s", err)
+		return ""
+	}
+
+	return cfg.CurrentContext
+}
+
+// contextEndpoint loads the docker endpoint of the named context from the
+// docker CLI context store.
+func contextEndpoint(name, source string) (*Endpoint, error) {
+	if name == defaultContext {
+		return &Endpoint{Host: os.Getenv("DOCKER_HOST"), Context: name, Source: source, fromEnv: true}, nil
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	contexts := filepath.Join(dockerConfigDir(), "contexts")
+
+	data, err := os.ReadFile(filepath.Join(contexts, "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("docker context '%s' not found", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load docker context '%s': %w", name, err)
+	}
+
+	meta := contextMeta{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse docker context '%s': %w", name, err)
+	}
+
+	docker, found := meta.Endpoints["docker"]
+	if !found {
+		return nil, fmt.Errorf("docker context '%s' has no docker endpoint", name)
+	}
+
+	endpoint := &Endpoint{
+		Host:          docker.Host,
+		Context:       name,
+		Source:        source,
+		SkipTLSVerify: docker.SkipTLSVerify,
+	}
+
+	tlsDir := filepath.Join(contexts, "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err == nil {
+		endpoint.TLSDir = tlsDir
+	}
+
+	return endpoint, nil
+}
+
+// ResolveEndpoint finds the Docker daemon to connect to. The order is the
+// --context option, DOCKER_HOST, DOCKER_CONTEXT, the docker-url local config
+// setting, the current context from the Docker config, and finally the
+// default socket.
+func ResolveEndpoint() (*Endpoint, error) {
+	if Context != "" {
+		return contextEndpoint(Context, "--context")
+	}
+
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return &Endpoint{Host: host, Source: "DOCKER_HOST", fromEnv: true}, nil
+	}
+
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return contextEndpoint(name, "DOCKER_CONTEXT")
+	}
+
+	if local := config.Local(); local.DockerURL != "" {
+		return &Endpoint{Host: local.DockerURL, Source: local.Path()}, nil
+	}
+
+	if name := currentContext(); name != "" {
+		return contextEndpoint(name, filepath.Join(dockerConfigDir(), "config.json"))
+	}
+
+	return &Endpoint{Source: "default"}, nil
+}
+
+// clientOpts returns the docker client options to connect to the endpoint.
+func (e *Endpoint) clientOpts() ([]client.Opt, error) {
+	if e.fromEnv {
+		// Picks up DOCKER_TLS_VERIFY and DOCKER_CERT_PATH along with
+		// DOCKER_HOST
+		return []client.Opt{client.FromEnv}, nil
+	}
+
+	opts := []client.Opt{}
+
+	if e.TLSDir != "" || e.SkipTLSVerify {
+		options := tlsconfig.Options{InsecureSkipVerify: e.SkipTLSVerify}
+		if e.TLSDir != "" {
+			for file, path := range map[string]*string{
+				"ca.pem":   &options.CAFile,
+				"cert.pem": &options.CertFile,
+				"key.pem":  &options.KeyFile,
+			} {
+				if full := filepath.Join(e.TLSDir, file); exists(full) {
+					*path = full
+				}
+			}
+		}
+
+		tlsConfig, err := tlsconfig.Client(options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS config for docker context '%s': %w", e.Context, err)
+		}
+
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsConfig},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+
+	if e.Host != "" {
+		opts = append(opts, client.WithHost(e.Host))
+	}
+
+	return opts, nil
+}
@@ -3,6 +3,11 @@ package docker
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
+
+	"github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
 
 	"wharfr.at/wharfrat/lib/config"
 	"wharfr.at/wharfrat/lib/docker/label"
@@ -10,6 +15,14 @@ import (
 )
 
 func (c *Connection) EnsureRunning(crate *config.Crate, force, removeOld bool) (string, error) {
+	if err := crate.CheckTrust(c); err != nil {
+		return "", err
+	}
+
+	if err := crate.CheckPolicy(); err != nil {
+		return "", err
+	}
+
 	container, err := c.GetContainer(crate.ContainerName())
 	if err != nil {
 		return "", fmt.Errorf("failed to get docker container: %w", err)
@@ -21,6 +34,30 @@ func (c *Connection) EnsureRunning(crate *config.Crate, force, removeOld bool) (
 
 	log.Printf("FOUND %s %s", container.ID, container.State.Status)
 
+	// Containers in these states can't be used or replaced as they are, so
+	// deal with them before checking whether they are stale
+	switch container.State.Status {
+	case "restarting", "removing":
+		if err := c.waitWhile(crate.ContainerName(), container.State.Status); err != nil {
+			return "", err
+		}
+		return c.EnsureRunning(crate, force, removeOld)
+	case "dead":
+		if !removeOld {
+			return "", fmt.Errorf("container is dead, use --clean to replace it, or 'wharfrat rm %s' to remove it", crate.ContainerName())
+		}
+		log.Printf("Automatically removing dead container")
+		if err := c.Remove(crate.ContainerName(), true); err != nil {
+			return "", err
+		}
+		return c.Create(crate)
+	}
+
+	// Pull first, since a new image may change the crate defaults
+	if err := c.ensurePulled(crate); err != nil {
+		return "", err
+	}
+
 	oldCommit := container.Config.Labels[label.Commit]
 	oldJson := container.Config.Labels[label.Config]
 	if oldJson != crate.Json() || oldCommit != version.Commit() {
@@ -33,17 +70,47 @@ func (c *Connection) EnsureRunning(crate *config.Crate, force, removeOld bool) (
 			}
 			return c.Create(crate)
 		} else {
-			return "", fmt.Errorf("container built from old config")
+			return "", fmt.Errorf("container built from old config (see 'wharfrat diff')")
 		}
 	}
 
+	missing, err := GUIMountsMissing(container, crate)
+	if err != nil {
+		return "", err
+	}
+	if len(missing) > 0 {
+		log.Printf("GUI MOUNTS: missing %s", strings.Join(missing, ", "))
+		if force {
+			log.Printf("Forcing use of container with old GUI sockets")
+		} else if removeOld {
+			log.Printf("Automatically removing container with old GUI sockets")
+			if err := c.Remove(crate.ContainerName(), true); err != nil {
+				return "", err
+			}
+			return c.Create(crate)
+		} else {
+			return "", fmt.Errorf("container doesn't have the current GUI sockets (see 'wharfrat diff')")
+		}
+	}
+
+	if err := c.ensureImage(crate); err != nil {
+		return "", err
+	}
+
 	image, err := c.GetImage(crate.Image)
 	if err != nil {
 		return "", err
 	}
 
-	if container.Image != image.ID {
-		log.Printf("CONTAINER IMAGE: wanted \"%s\", got \"%s\"", image.ID, container.Image)
+	// Read-only containers are created from an image of the set up container,
+	// so check the image that was used for the setup instead
+	containerImage := container.Image
+	if base := container.Config.Labels[label.BaseImage]; base != "" {
+		containerImage = base
+	}
+
+	if containerImage != image.ID {
+		log.Printf("CONTAINER IMAGE: wanted \"%s\", got \"%s\"", image.ID, containerImage)
 		if force {
 			log.Printf("Forcing use of container built from old image")
 		} else if removeOld {
@@ -53,29 +120,34 @@ func (c *Connection) EnsureRunning(crate *config.Crate, force, removeOld bool) (
 			}
 			return c.Create(crate)
 		} else {
-			return "", fmt.Errorf("container built from wrong (old?) image")
+			return "", fmt.Errorf("container built from wrong (old?) image (see 'wharfrat diff')")
 		}
 	}
 
 	switch container.State.Status {
 	case "created":
-		return "", fmt.Errorf("state %s NOT IMPLEMENTED", container.State.Status)
+		// The container was never started, probably because the create was
+		// interrupted, so finish it off
+		if crate.ReadOnly && container.Config.Labels[label.BaseImage] == "" {
+			// A read-only container can only be set up by creating it again
+			if err := c.Remove(crate.ContainerName(), true); err != nil {
+				return "", err
+			}
+			return c.Create(crate)
+		}
+		if err := c.startCreated(container, crate); err != nil {
+			return "", fmt.Errorf("failed to start created container (use 'wharfrat rm %s' to remove it): %w", crate.ContainerName(), err)
+		}
 	case "running":
 		log.Printf("RUNNING")
 	case "paused":
 		if err := c.Unpause(container.ID); err != nil {
 			return "", fmt.Errorf("failed to start container: %w", err)
 		}
-	case "restarting":
-		return "", fmt.Errorf("state %s NOT IMPLEMENTED", container.State.Status)
-	case "removing":
-		return "", fmt.Errorf("state %s NOT IMPLEMENTED", container.State.Status)
 	case "exited":
 		if err := c.Start(container.ID); err != nil {
 			return "", fmt.Errorf("failed to start container: %w", err)
 		}
-	case "dead":
-		return "", fmt.Errorf("state %s NOT IMPLEMENTED", container.State.Status)
 	default:
 		return "", fmt.Errorf("invalid container state: %s", container.State.Status)
 	}
@@ -83,6 +155,47 @@ func (c *Connection) EnsureRunning(crate *config.Crate, force, removeOld bool) (
 	return container.ID, nil
 }
 
+// stateTimeout is how long to wait for a container to leave a transient state
+// (e.g. restarting) before giving up.
+const stateTimeout = 30 * time.Second
+
+// waitWhile waits for the named container to leave the given state, or to be
+// removed.
+func (c *Connection) waitWhile(name, state string) error {
+	deadline := time.Now().Add(stateTimeout)
+	for time.Now().Before(deadline) {
+		info, err := c.c.ContainerInspect(c.ctx, name)
+		if errdefs.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.State.Status != state {
+			log.Printf("STATE: %s -> %s", state, info.State.Status)
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return fmt.Errorf("container still %s after %s, try again later, or use 'wharfrat rm %s' to remove it", state, stateTimeout, name)
+}
+
+// startCreated starts a container that was created but never started. If the
+// container has already been set up (i.e. it is a read-only container) then
+// it is just started, otherwise it is set up as well.
+func (c *Connection) startCreated(info *container.InspectResponse, crate *config.Crate) error {
+	if info.Config.Labels[label.BaseImage] != "" {
+		return c.Start(info.ID)
+	}
+
+	if err := c.startAndSetup(info.ID, crate); err != nil {
+		return err
+	}
+
+	c.notifyCreated(info.ID, crate)
+
+	return nil
+}
+
 func (c *Connection) EnsureStopped(name string) error {
 	log.Printf("STOP %s", name)
 
@@ -141,5 +254,6 @@ func (c *Connection) EnsureRemoved(name string) error {
 
 	log.Printf("FOUND %s %s", container.ID, container.State.Status)
 
+	// Volumes are not removed with the container, so that caches are kept
 	return c.Remove(name, true)
 }
@@ -25,7 +25,7 @@ import (
 // blacklist is applied to the host environment to prevent the container
 // environment from picking up settings that don't make sense (e.g. taking PATH
 // into the container).
-func buildEnv(id string, crate *config.Crate) ([]string, error) {
+func (c *Connection) buildEnv(id string, crate *config.Crate) ([]string, error) {
 	env := []string{
 		"WHARFRAT_ID=" + id,
 		"WHARFRAT_NAME=" + crate.ContainerName(),
@@ -56,7 +56,7 @@ func buildEnv(id string, crate *config.Crate) ([]string, error) {
 			case "WHARFRAT_ID", "WHARFRAT_NAME", "WHARFRAT_CRATE", "WHARFRAT_PROJECT", "WHARFRAT_PROJECT_DIR":
 				log.Printf("Ignoring attempt to change %s", name)
 			default:
-				env = append(env, name+"="+value)
+				env = append(env, name+"="+config.Expand(value, crate.Getenv))
 			}
 		}
 	}
@@ -101,6 +101,29 @@ func buildEnv(id string, crate *config.Crate) ([]string, error) {
 		blacklist[name] = true
 	}
 
+	gui, replaced, err := guiEnv(crate)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range replaced {
+		blacklist[name] = true
+	}
+	env = append(env, gui...)
+
+	if err := c.startGPGAgent(crate); err != nil {
+		return nil, err
+	}
+
+	agent, err := c.sshAgentSocket(crate)
+	if err != nil {
+		return nil, err
+	}
+	if agent != "" {
+		// The host socket isn't available in the container
+		blacklist["SSH_AUTH_SOCK"] = true
+		env = append(env, "SSH_AUTH_SOCK="+agent)
+	}
+
 	for _, entry := range os.Environ() {
 		if parts := strings.SplitN(entry, "=", 2); !blacklist[parts[0]] {
 			env = append(env, entry)
@@ -204,7 +227,7 @@ func (c *Connection) ExecCmd(id string, cmd []string, crate *config.Crate, user,
 		cmds = append(proxy, cmds...)
 	}
 
-	env, err := buildEnv(id, crate)
+	env, err := c.buildEnv(id, crate)
 	if err != nil {
 		return 0, err
 	}
@@ -352,7 +375,7 @@ func (c *Connection) GetOutput(id string, cmd []string, crate *config.Crate, use
 
 	log.Printf("GET OUTPUT (%s): %v", user, cmd)
 
-	env, err := buildEnv(id, crate)
+	env, err := c.buildEnv(id, crate)
 	if err != nil {
 		return nil, nil, err
 	}
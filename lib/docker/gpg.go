@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// gpgAgentDir is where the GPG agent socket and the host's public keys are
+// found in containers.
+const gpgAgentDir = "/run/wharfrat/gpg-agent"
+
+// gpgAgentSocket is the name of the link to the socket of the current
+// wharfrat session in the GPG agent directory.
+const gpgAgentSocket = "S.gpg-agent"
+
+// gpgSetupScript links the agent socket into the user's runtime directory
+// (/run/user/UID, using the user's UID in the container), which is where gpg
+// looks for it when the directory exists, so that the home directory is left
+// alone when it is mounted from the host. When asked to, it also imports the
+// public keys that were exported from the host into the user's GnuPG home. It
+// is run as root with the home directory, user, group and whether to import
+// keys as arguments.
+const gpgSetupScript = `set -e
+run="/run/user/$(id -u "$2")"
+sockets="$run/gnupg"
+gnupg="$1/.gnupg"
+dir=` + gpgAgentDir + `
+mkdir -p "$sockets"
+chown "$2:$3" "$run" "$sockets"
+chmod 700 "$run" "$sockets"
+ln -sfn "$dir/S.gpg-agent" "$sockets/S.gpg-agent"
+chown -h "$2:$3" "$sockets/S.gpg-agent"
+[ "$4" = import ] || exit 0
+mkdir -p "$gnupg"
+chown "$2:$3" "$gnupg"
+chmod 700 "$gnupg"
+grep -qsx no-autostart "$gnupg/gpg.conf" || echo no-autostart >> "$gnupg/gpg.conf"
+if command -v gpg >/dev/null; then
+	if [ -s "$dir/pubring.asc" ]; then
+		gpg --batch --quiet --no-autostart --homedir "$gnupg" --import "$dir/pubring.asc"
+	fi
+	if [ -s "$dir/ownertrust.txt" ]; then
+		gpg --batch --quiet --no-autostart --homedir "$gnupg" --import-ownertrust "$dir/ownertrust.txt"
+	fi
+fi
+chown -R "$2:$3" "$gnupg"
+`
+
+// gpgAgentBind returns the bind mount that gives the crate's container access
+// to the GPG agent.
+func gpgAgentBind(crate *config.Crate) (string, error) {
+	dir, err := crateHostDir("gpg-agent", crate)
+	if err != nil {
+		return "", err
+	}
+
+	return dir + ":" + gpgAgentDir, nil
+}
+
+// gpgExtraSocket returns the path of the host agent's extra socket, which is
+// the one meant for remote use.
+func gpgExtraSocket() (string, error) {
+	out, err := exec.Command("gpgconf", "--list-dirs", "agent-extra-socket").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run gpgconf: %w", err)
+	}
+
+	return url.PathUnescape(strings.TrimSpace(string(out)))
+}
+
+// exportGPGKeys writes the host's public keys and owner trust to dir, so that
+// they can be imported in the container.
+func exportGPGKeys(dir string) error {
+	files := map[string][]string{
+		"pubring.asc":    {"--batch", "--armor", "--export"},
+		"ownertrust.txt": {"--batch", "--export-ownertrust"},
+	}
+
+	for name, args := range files {
+		stderr := &bytes.Buffer{}
+		cmd := exec.Command("gpg", args...)
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("gpg %s failed: %w: %s", args[len(args)-1], err, stderr)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), out, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setupGPGAgent makes the GPG agent socket appear where gpg expects it for the
+// user in the container. When the home directory isn't mounted from the host
+// the host's public keys are imported too, since the agent only holds the
+// secret keys.
+func (c *Connection) setupGPGAgent(id string, crate *config.Crate, usr *user.User, group *user.Group) error {
+	if !crate.GPGAgent || runtime.GOOS == "darwin" {
+		return nil
+	}
+
+	dir, err := crateHostDir("gpg-agent", crate)
+	if err != nil {
+		return err
+	}
+
+	action := "link"
+	if !crate.MountHome {
+		if err := exportGPGKeys(dir); err != nil {
+			return fmt.Errorf("failed to export GPG public keys: %w", err)
+		}
+		action = "import"
+	}
+
+	home, err := c.homedir(id, usr.Username)
+	if err != nil {
+		return err
+	}
+
+	cmd := []string{"/bin/sh", "-s", "--", home, usr.Username, group.Name, action}
+	buf := &bytes.Buffer{}
+	exitCode, err := c.run(id, cmd, nil, strings.NewReader(gpgSetupScript), buf, buf)
+	if err != nil {
+		return fmt.Errorf("failed to set up GPG agent: %w", err)
+	}
+
+	log.Printf("GPG SETUP: %d %s", exitCode, buf)
+
+	if exitCode != 0 {
+		return fmt.Errorf("failed to set up GPG agent (%d): %s", exitCode, buf)
+	}
+
+	return nil
+}
+
+// startGPGAgent forwards the GPG agent socket in the crate's container to the
+// host agent's extra socket for as long as wharfrat is running.
+func (c *Connection) startGPGAgent(crate *config.Crate) error {
+	if !crate.GPGAgent || runtime.GOOS == "darwin" || c.gpgAgent {
+		return nil
+	}
+
+	hostSocket, err := gpgExtraSocket()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: gpg-agent is not available: %s\n", err)
+		return nil
+	}
+
+	dir, err := crateHostDir("gpg-agent", crate)
+	if err != nil {
+		return err
+	}
+
+	if err := c.startAgentProxy(dir, gpgAgentSocket, hostSocket); err != nil {
+		return fmt.Errorf("failed to create GPG agent socket: %w", err)
+	}
+
+	c.gpgAgent = true
+
+	return nil
+}
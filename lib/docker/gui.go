@@ -0,0 +1,466 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// x11Dir is where the X authority file for the crate is found in containers.
+const x11Dir = "/run/wharfrat/x11"
+
+// pulseCookie is where the PulseAudio cookie is found in containers.
+const pulseCookie = "/run/wharfrat/pulse/cookie"
+
+// The X authority families that are used when copying cookies.
+const (
+	xauthFamilyLocal = 256
+	xauthFamilyWild  = 65535
+)
+
+// containerRuntimeDir returns XDG_RUNTIME_DIR for the user in containers,
+// which is where the Wayland, PulseAudio and PipeWire sockets are mounted.
+func containerRuntimeDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user information: %w", err)
+	}
+
+	return "/run/user/" + usr.Uid, nil
+}
+
+// usesRuntimeDir returns true if the crate uses GUI features that have their
+// sockets in XDG_RUNTIME_DIR.
+func usesRuntimeDir(crate *config.Crate) bool {
+	return crate.UsesGUI(config.GUIWayland) || crate.UsesGUI(config.GUIPulse) || crate.UsesGUI(config.GUIPipeWire)
+}
+
+// hostSocket returns the path of the socket for the GUI feature, which is
+// relative to XDG_RUNTIME_DIR unless it is absolute.
+func hostSocket(feature, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		dir := os.Getenv("XDG_RUNTIME_DIR")
+		if dir == "" {
+			return "", fmt.Errorf("gui %s: XDG_RUNTIME_DIR is not set", feature)
+		}
+		path = filepath.Join(dir, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return "", fmt.Errorf("gui %s: no socket found at %s", feature, path)
+	}
+
+	return path, nil
+}
+
+// x11Display returns the display number from DISPLAY. Only local displays
+// (i.e. reached through /tmp/.X11-unix) can be used, since the container
+// can't reach a display forwarded by ssh on the host's loopback address.
+func x11Display() (string, error) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return "", fmt.Errorf("gui x11: DISPLAY is not set")
+	}
+
+	idx := strings.LastIndex(display, ":")
+	if idx < 0 {
+		return "", fmt.Errorf("gui x11: invalid DISPLAY '%s'", display)
+	}
+	if host := display[:idx]; host != "" && host != "unix" {
+		return "", fmt.Errorf("gui x11: DISPLAY '%s' is not a local display", display)
+	}
+	number, _, _ := strings.Cut(display[idx+1:], ".")
+
+	return number, nil
+}
+
+func waylandSocket() (string, error) {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		return "", fmt.Errorf("gui wayland: WAYLAND_DISPLAY is not set")
+	}
+	return hostSocket(config.GUIWayland, name)
+}
+
+func pulseSocket() (string, error) {
+	if server, found := strings.CutPrefix(os.Getenv("PULSE_SERVER"), "unix:"); found {
+		return hostSocket(config.GUIPulse, server)
+	}
+	return hostSocket(config.GUIPulse, "pulse/native")
+}
+
+// pulseCookiePath returns the host PulseAudio cookie, or an empty string if
+// there isn't one (e.g. with pipewire-pulse, which doesn't need it).
+func pulseCookiePath() string {
+	path := os.Getenv("PULSE_COOKIE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(dir, "pulse", "cookie")
+	}
+	if !exists(path) {
+		return ""
+	}
+	return path
+}
+
+func pipewireSocket() (string, error) {
+	if remote := os.Getenv("PIPEWIRE_REMOTE"); remote != "" {
+		return hostSocket(config.GUIPipeWire, remote)
+	}
+	return hostSocket(config.GUIPipeWire, "pipewire-0")
+}
+
+// guiFeatureBinds returns the bind mounts for one of the GUI features used by
+// the crate, failing if the host doesn't provide it.
+func guiFeatureBinds(crate *config.Crate, feature, runtimeDir string) ([]string, error) {
+	switch feature {
+	case config.GUIX11:
+		display, err := x11Display()
+		if err != nil {
+			return nil, err
+		}
+		socket := "/tmp/.X11-unix/X" + display
+		if !exists(socket) {
+			return nil, fmt.Errorf("gui x11: no socket found at %s", socket)
+		}
+		dir, err := crateHostDir("x11", crate)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"/tmp/.X11-unix:/tmp/.X11-unix", dir + ":" + x11Dir}, nil
+
+	case config.GUIWayland:
+		socket, err := waylandSocket()
+		if err != nil {
+			return nil, err
+		}
+		return []string{socket + ":" + runtimeDir + "/" + filepath.Base(socket)}, nil
+
+	case config.GUIPulse:
+		socket, err := pulseSocket()
+		if err != nil {
+			return nil, err
+		}
+		binds := []string{socket + ":" + runtimeDir + "/pulse/native"}
+		if cookie := pulseCookiePath(); cookie != "" {
+			binds = append(binds, cookie+":"+pulseCookie+":ro")
+		}
+		return binds, nil
+
+	case config.GUIPipeWire:
+		socket, err := pipewireSocket()
+		if err != nil {
+			return nil, err
+		}
+		return []string{socket + ":" + runtimeDir + "/pipewire-0"}, nil
+	}
+
+	return nil, nil
+}
+
+// guiBinds returns the bind mounts for the GUI features used by the crate,
+// failing if the host doesn't provide them, or leaving them out if skip is
+// true. Crates that don't ask for x11 still get the X11 sockets if there are
+// any, as they always have.
+func guiBinds(crate *config.Crate, skip bool) ([]string, error) {
+	binds := []string{}
+
+	if !crate.UsesGUI(config.GUIX11) && exists("/tmp/.X11-unix") {
+		binds = append(binds, "/tmp/.X11-unix:/tmp/.X11-unix")
+	}
+
+	runtimeDir, err := containerRuntimeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, feature := range []string{config.GUIX11, config.GUIWayland, config.GUIPulse, config.GUIPipeWire} {
+		if !crate.UsesGUI(feature) {
+			continue
+		}
+		featureBinds, err := guiFeatureBinds(crate, feature, runtimeDir)
+		if err != nil && skip {
+			log.Printf("GUI: skipping %s", err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		binds = append(binds, featureBinds...)
+	}
+
+	return binds, nil
+}
+
+// GUIMountsMissing returns the GUI bind mounts that the crate's container
+// doesn't have, since the host sockets may have moved since it was created
+// (e.g. after logging in again with a different WAYLAND_DISPLAY). Features
+// that the host doesn't provide at the moment (e.g. over ssh) are left out,
+// since recreating the container wouldn't help.
+func GUIMountsMissing(info *container.InspectResponse, crate *config.Crate) ([]string, error) {
+	if len(crate.GUI) == 0 {
+		return nil, nil
+	}
+
+	binds, err := guiBinds(crate, true)
+	if err != nil {
+		return nil, err
+	}
+
+	mounted := map[string]bool{}
+	for _, m := range info.Mounts {
+		mounted[filepath.Clean(m.Source)+":"+filepath.Clean(m.Destination)] = true
+	}
+
+	missing := []string{}
+	for _, bind := range binds {
+		parts := strings.Split(bind, ":")
+		if !crate.UsesGUI(config.GUIX11) && parts[0] == "/tmp/.X11-unix" {
+			// Only mounted if it exists, so it can appear later
+			continue
+		}
+		if !mounted[filepath.Clean(parts[0])+":"+filepath.Clean(parts[1])] {
+			missing = append(missing, parts[0]+":"+parts[1])
+		}
+	}
+
+	return missing, nil
+}
+
+// guiEnv returns the environment variables that point programs in the
+// container at the GUI features used by the crate, along with the names of
+// the host variables that they replace. The host variables are replaced even
+// when the host doesn't provide a feature at the moment (e.g. over ssh), which
+// only gives a warning, since they don't work in the container.
+func guiEnv(crate *config.Crate) ([]string, []string, error) {
+	env := []string{}
+	replaced := []string{}
+	set := func(name, value string) {
+		env = append(env, name+"="+value)
+		replaced = append(replaced, name)
+	}
+	warn := func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+	}
+
+	if usesRuntimeDir(crate) {
+		runtimeDir, err := containerRuntimeDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		set("XDG_RUNTIME_DIR", runtimeDir)
+	}
+
+	if crate.UsesGUI(config.GUIX11) {
+		found := false
+		if display, err := x11Display(); err != nil {
+			warn(err)
+		} else {
+			dir, err := crateHostDir("x11", crate)
+			if err != nil {
+				return nil, nil, err
+			}
+			found, err = copyXauth(filepath.Join(dir, "Xauthority"), display)
+			if err != nil {
+				warn(fmt.Errorf("gui x11: failed to copy X authority: %w", err))
+			}
+		}
+		if found {
+			set("XAUTHORITY", x11Dir+"/Xauthority")
+		} else {
+			// The host file isn't available in the container
+			replaced = append(replaced, "XAUTHORITY")
+		}
+	}
+
+	if crate.UsesGUI(config.GUIWayland) {
+		if socket, err := waylandSocket(); err != nil {
+			warn(err)
+			replaced = append(replaced, "WAYLAND_DISPLAY")
+		} else {
+			set("WAYLAND_DISPLAY", filepath.Base(socket))
+		}
+	}
+
+	if crate.UsesGUI(config.GUIPulse) {
+		if _, err := pulseSocket(); err != nil {
+			warn(err)
+			replaced = append(replaced, "PULSE_SERVER", "PULSE_COOKIE")
+		} else {
+			runtimeDir, err := containerRuntimeDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			set("PULSE_SERVER", "unix:"+runtimeDir+"/pulse/native")
+			if pulseCookiePath() != "" {
+				set("PULSE_COOKIE", pulseCookie)
+			} else {
+				replaced = append(replaced, "PULSE_COOKIE")
+			}
+		}
+	}
+
+	if crate.UsesGUI(config.GUIPipeWire) {
+		if _, err := pipewireSocket(); err != nil {
+			warn(err)
+		}
+		// The socket is mounted at the default name
+		replaced = append(replaced, "PIPEWIRE_REMOTE")
+	}
+
+	return env, replaced, nil
+}
+
+// setupGUI makes the runtime directory in the container belong to the user,
+// as programs expect.
+func (c *Connection) setupGUI(id string, crate *config.Crate, usr *user.User, group *user.Group) error {
+	if !usesRuntimeDir(crate) {
+		return nil
+	}
+
+	runtimeDir, err := containerRuntimeDir()
+	if err != nil {
+		return err
+	}
+
+	script := `mkdir -p "$1" && chown "$2:$3" "$1" && chmod 700 "$1"`
+	cmd := []string{"/bin/sh", "-c", script, "sh", runtimeDir, usr.Username, group.Name}
+	buf := &bytes.Buffer{}
+	exitCode, err := c.run(id, cmd, nil, nil, buf, buf)
+	if err != nil {
+		return fmt.Errorf("failed to set up runtime directory: %w", err)
+	}
+
+	// Not fatal, e.g. the root filesystem may be read-only
+	if exitCode != 0 {
+		log.Printf("GUI SETUP: failed to set up %s (%d): %s", runtimeDir, exitCode, buf)
+	}
+
+	return nil
+}
+
+// xauthEntry is an entry in an X authority file, which is a list of entries
+// made up of a family, followed by the address, display number, auth name and
+// auth data as length prefixed strings.
+type xauthEntry struct {
+	family  uint16
+	address []byte
+	number  []byte
+	name    []byte
+	data    []byte
+}
+
+func readXauth(r io.Reader) ([]xauthEntry, error) {
+	entries := []xauthEntry{}
+	for {
+		entry := xauthEntry{}
+		if err := binary.Read(r, binary.BigEndian, &entry.family); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, field := range []*[]byte{&entry.address, &entry.number, &entry.name, &entry.data} {
+			var length uint16
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return nil, err
+			}
+			*field = make([]byte, length)
+			if _, err := io.ReadFull(r, *field); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (e xauthEntry) write(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, e.family); err != nil {
+		return err
+	}
+	for _, field := range [][]byte{e.address, e.number, e.name, e.data} {
+		if err := binary.Write(w, binary.BigEndian, uint16(len(field))); err != nil {
+			return err
+		}
+		if _, err := w.Write(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyXauth writes the host's cookies for display to path, returning false if
+// there aren't any. The cookies are made to match any host, since the
+// container has a different hostname.
+func copyXauth(path, display string) (bool, error) {
+	src := os.Getenv("XAUTHORITY")
+	if src == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return false, err
+		}
+		src = filepath.Join(home, ".Xauthority")
+	}
+
+	f, err := os.Open(src)
+	if os.IsNotExist(err) {
+		log.Printf("XAUTH: no authority file at %s", src)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	entries, err := readXauth(f)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", src, err)
+	}
+
+	hostname, _ := os.Hostname()
+	matches := []xauthEntry{}
+	for _, entry := range entries {
+		if string(entry.number) == display {
+			matches = append(matches, entry)
+		}
+	}
+	if len(matches) == 0 {
+		log.Printf("XAUTH: no cookie for display %s in %s", display, src)
+		return false, nil
+	}
+
+	// Put the entries for this host first, since the first match is used
+	sort.SliceStable(matches, func(i, j int) bool {
+		local := func(e xauthEntry) bool {
+			return e.family == xauthFamilyLocal && string(e.address) == hostname
+		}
+		return local(matches[i]) && !local(matches[j])
+	})
+
+	buf := &bytes.Buffer{}
+	for _, entry := range matches {
+		entry.family = xauthFamilyWild
+		if err := entry.write(buf); err != nil {
+			return false, err
+		}
+	}
+
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return false, err
+	}
+
+	return true, os.Rename(tmp, path)
+}
@@ -0,0 +1,134 @@
+package docker
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadXauth(t *testing.T) {
+	entries := []xauthEntry{
+		{family: xauthFamilyLocal, address: []byte("host"), number: []byte("0"), name: []byte("MIT-MAGIC-COOKIE-1"), data: []byte{1, 2, 3, 4}},
+		{family: xauthFamilyWild, address: []byte{}, number: []byte("1"), name: []byte("MIT-MAGIC-COOKIE-1"), data: []byte{5, 6}},
+	}
+
+	buf := &bytes.Buffer{}
+	for _, entry := range entries {
+		if err := entry.write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := buf.Bytes()
+
+	got, err := readXauth(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %+v, want %+v", got, entries)
+	}
+
+	if got, err := readXauth(bytes.NewReader(nil)); err != nil || len(got) != 0 {
+		t.Errorf("empty file: got %+v, %v", got, err)
+	}
+
+	for _, n := range []int{1, 3, len(data) - 1} {
+		if _, err := readXauth(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("truncated to %d bytes: got no error", n)
+		}
+	}
+}
+
+func TestCopyXauth(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "host-Xauthority")
+	t.Setenv("XAUTHORITY", src)
+
+	cookie := func(family uint16, address, number string, data byte) xauthEntry {
+		return xauthEntry{family: family, address: []byte(address), number: []byte(number), name: []byte("MIT-MAGIC-COOKIE-1"), data: []byte{data}}
+	}
+	buf := &bytes.Buffer{}
+	for _, entry := range []xauthEntry{
+		cookie(xauthFamilyLocal, "other", "0", 1),
+		cookie(xauthFamilyLocal, hostname, "0", 2),
+		cookie(xauthFamilyLocal, hostname, "1", 3),
+	} {
+		if err := entry.write(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(src, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "Xauthority")
+	found, err := copyXauth(dst, "0")
+	if err != nil || !found {
+		t.Fatalf("got %v, %v", found, err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := readXauth(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cookies for the display are made to match any host, with the one
+	// for this host first
+	want := []xauthEntry{
+		cookie(xauthFamilyWild, hostname, "0", 2),
+		cookie(xauthFamilyWild, "other", "0", 1),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if found, err := copyXauth(filepath.Join(dir, "none"), "2"); err != nil || found {
+		t.Errorf("no cookie for display: got %v, %v", found, err)
+	}
+
+	t.Setenv("XAUTHORITY", filepath.Join(dir, "missing"))
+	if found, err := copyXauth(filepath.Join(dir, "none"), "0"); err != nil || found {
+		t.Errorf("no authority file: got %v, %v", found, err)
+	}
+}
+
+func TestX11Display(t *testing.T) {
+	tests := []struct {
+		display string
+		want    string
+		err     bool
+	}{
+		{":0", "0", false},
+		{":1.0", "1", false},
+		{"unix:2", "2", false},
+		{"localhost:10.0", "", true},
+		{"", "", true},
+		{"0", "", true},
+	}
+
+	for _, test := range tests {
+		t.Setenv("DISPLAY", test.display)
+		got, err := x11Display()
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: got no error", test.display)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.display, got, err, test.want)
+		}
+	}
+}
@@ -10,9 +10,19 @@ const (
 	Config  = domain + ".config"
 	Branch  = domain + ".branch"
 	User    = domain + ".user"
+
+	// Set on read-only containers, which are created from an image of the
+	// container after setup
+	BaseImage  = domain + ".base-image"
+	SetupImage = domain + ".setup-image"
 )
 
 // Labels intended for use on images
 const (
 	Shell = domain + ".shell"
 )
+
+// Labels intended for use on volumes (along with Project, Crate and User)
+const (
+	Cache = domain + ".cache"
+)
@@ -0,0 +1,668 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// libpodNamespace is a namespace setting in a libpod container spec.
+type libpodNamespace struct {
+	Mode  string `json:"nsmode"`
+	Value string `json:"value,omitempty"`
+}
+
+type libpodMount struct {
+	Type        string   `json:"type"`
+	Source      string   `json:"source,omitempty"`
+	Destination string   `json:"destination"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type libpodVolume struct {
+	Name    string   `json:"Name"`
+	Dest    string   `json:"Dest"`
+	Options []string `json:"Options,omitempty"`
+}
+
+type libpodPort struct {
+	HostIP        string `json:"host_ip,omitempty"`
+	ContainerPort uint16 `json:"container_port"`
+	HostPort      uint16 `json:"host_port,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type libpodDevice struct {
+	Path string `json:"path"`
+}
+
+type libpodDeviceRule struct {
+	Allow  bool   `json:"allow"`
+	Type   string `json:"type,omitempty"`
+	Major  *int64 `json:"major,omitempty"`
+	Minor  *int64 `json:"minor,omitempty"`
+	Access string `json:"access,omitempty"`
+}
+
+type libpodRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type libpodResources struct {
+	Memory *struct {
+		Limit *int64 `json:"limit,omitempty"`
+		Swap  *int64 `json:"swap,omitempty"`
+	} `json:"memory,omitempty"`
+	CPU *struct {
+		Quota  *int64  `json:"quota,omitempty"`
+		Period *uint64 `json:"period,omitempty"`
+		Cpus   string  `json:"cpus,omitempty"`
+	} `json:"cpu,omitempty"`
+	Pids *struct {
+		Limit int64 `json:"limit"`
+	} `json:"pids,omitempty"`
+}
+
+// libpodSpec is the part of the libpod container spec (SpecGenerator) that
+// wharfrat uses.
+type libpodSpec struct {
+	Name               string              `json:"name"`
+	Image              string              `json:"image"`
+	ImageOS            string              `json:"image_os,omitempty"`
+	ImageArch          string              `json:"image_arch,omitempty"`
+	ImageVariant       string              `json:"image_variant,omitempty"`
+	Hostname           string              `json:"hostname,omitempty"`
+	Labels             map[string]string   `json:"labels,omitempty"`
+	Entrypoint         []string            `json:"entrypoint"`
+	Command            []string            `json:"command,omitempty"`
+	User               string              `json:"user,omitempty"`
+	Env                map[string]string   `json:"env,omitempty"`
+	WorkDir            string              `json:"work_dir,omitempty"`
+	Expose             map[uint16]string   `json:"expose,omitempty"`
+	PortMappings       []libpodPort        `json:"portmappings,omitempty"`
+	Mounts             []libpodMount       `json:"mounts,omitempty"`
+	Volumes            []libpodVolume      `json:"volumes,omitempty"`
+	CapAdd             []string            `json:"cap_add,omitempty"`
+	CapDrop            []string            `json:"cap_drop,omitempty"`
+	Privileged         bool                `json:"privileged,omitempty"`
+	Devices            []libpodDevice      `json:"devices,omitempty"`
+	DeviceCgroupRules  []libpodDeviceRule  `json:"device_cgroup_rule,omitempty"`
+	ReadOnly           bool                `json:"read_only_filesystem,omitempty"`
+	ShmSize            *int64              `json:"shm_size,omitempty"`
+	Rlimits            []libpodRlimit      `json:"r_limits,omitempty"`
+	Resources          *libpodResources    `json:"resource_limits,omitempty"`
+	NetNS              *libpodNamespace    `json:"netns,omitempty"`
+	Networks           map[string]struct{} `json:"Networks,omitempty"`
+	UserNS             *libpodNamespace    `json:"userns,omitempty"`
+	SeccompProfilePath string              `json:"seccomp_profile_path,omitempty"`
+	ApparmorProfile    string              `json:"apparmor_profile,omitempty"`
+	SelinuxOpts        []string            `json:"selinux_opts,omitempty"`
+	NoNewPrivileges    bool                `json:"no_new_privileges,omitempty"`
+	Unmask             []string            `json:"unmask,omitempty"`
+}
+
+// libpodContainer is the part of the libpod container inspect response that
+// wharfrat uses.
+type libpodContainer struct {
+	ID      string   `json:"Id"`
+	Created string   `json:"Created"`
+	Path    string   `json:"Path"`
+	Args    []string `json:"Args"`
+	State   struct {
+		Status     string `json:"Status"`
+		Running    bool   `json:"Running"`
+		Paused     bool   `json:"Paused"`
+		Restarting bool   `json:"Restarting"`
+		OOMKilled  bool   `json:"OOMKilled"`
+		Dead       bool   `json:"Dead"`
+		Pid        int    `json:"Pid"`
+		ExitCode   int    `json:"ExitCode"`
+		Error      string `json:"Error"`
+		StartedAt  string `json:"StartedAt"`
+		FinishedAt string `json:"FinishedAt"`
+	} `json:"State"`
+	Image     string `json:"Image"`
+	ImageName string `json:"ImageName"`
+	Name      string `json:"Name"`
+	Mounts    []struct {
+		Type        string `json:"Type"`
+		Name        string `json:"Name"`
+		Source      string `json:"Source"`
+		Destination string `json:"Destination"`
+		Driver      string `json:"Driver"`
+		Mode        string `json:"Mode"`
+		RW          bool   `json:"RW"`
+		Propagation string `json:"Propagation"`
+	} `json:"Mounts"`
+	HostConfig struct {
+		Binds          []string          `json:"Binds"`
+		CapAdd         []string          `json:"CapAdd"`
+		CapDrop        []string          `json:"CapDrop"`
+		NetworkMode    string            `json:"NetworkMode"`
+		Privileged     bool              `json:"Privileged"`
+		ReadonlyRootfs bool              `json:"ReadonlyRootfs"`
+		SecurityOpt    []string          `json:"SecurityOpt"`
+		ShmSize        int64             `json:"ShmSize"`
+		Tmpfs          map[string]string `json:"Tmpfs"`
+		UsernsMode     string            `json:"UsernsMode"`
+	} `json:"HostConfig"`
+	Config struct {
+		Hostname   string            `json:"Hostname"`
+		User       string            `json:"User"`
+		Env        []string          `json:"Env"`
+		Cmd        []string          `json:"Cmd"`
+		WorkingDir string            `json:"WorkingDir"`
+		Labels     map[string]string `json:"Labels"`
+		Entrypoint json.RawMessage   `json:"Entrypoint"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			NetworkID         string `json:"NetworkID"`
+			EndpointID        string `json:"EndpointID"`
+			Gateway           string `json:"Gateway"`
+			IPAddress         string `json:"IPAddress"`
+			IPPrefixLen       int    `json:"IPPrefixLen"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+			MacAddress        string `json:"MacAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// libpodStates maps the container states that only Podman has to the nearest
+// Docker state.
+var libpodStates = map[string]container.ContainerState{
+	"configured":  container.StateCreated,
+	"initialized": container.StateCreated,
+	"stopped":     container.StateExited,
+	"stopping":    container.StateRestarting,
+	"unknown":     container.StateDead,
+}
+
+// ContainerCreate creates a container using the libpod API, translating the
+// Docker container config into a libpod spec.
+func (p *podmanRuntime) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error) {
+	spec, err := libpodSpecFor(config, hostConfig, containerName)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+
+	if platform != nil {
+		spec.ImageOS = platform.OS
+		spec.ImageArch = platform.Architecture
+		spec.ImageVariant = platform.Variant
+	}
+
+	resp := struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}{}
+	if err := p.call(ctx, http.MethodPost, "/containers/create", nil, spec, &resp); err != nil {
+		return container.CreateResponse{}, err
+	}
+
+	return container.CreateResponse{ID: resp.ID, Warnings: resp.Warnings}, nil
+}
+
+// libpodSpecFor translates the parts of a Docker container config that
+// wharfrat sets into a libpod spec.
+func libpodSpecFor(config *container.Config, hostConfig *container.HostConfig, name string) (*libpodSpec, error) {
+	spec := &libpodSpec{
+		Name:       name,
+		Image:      config.Image,
+		Hostname:   config.Hostname,
+		Labels:     config.Labels,
+		Entrypoint: config.Entrypoint,
+		Command:    config.Cmd,
+		User:       config.User,
+		WorkDir:    config.WorkingDir,
+		CapAdd:     hostConfig.CapAdd,
+		CapDrop:    hostConfig.CapDrop,
+		Privileged: hostConfig.Privileged,
+		ReadOnly:   hostConfig.ReadonlyRootfs,
+	}
+
+	if spec.Entrypoint == nil {
+		spec.Entrypoint = []string{}
+	}
+
+	if len(config.Env) > 0 {
+		spec.Env = map[string]string{}
+		for _, entry := range config.Env {
+			key, value, _ := strings.Cut(entry, "=")
+			spec.Env[key] = value
+		}
+	}
+
+	if len(config.ExposedPorts) > 0 {
+		spec.Expose = map[uint16]string{}
+		for port := range config.ExposedPorts {
+			spec.Expose[uint16(port.Int())] = port.Proto()
+		}
+	}
+
+	for port, bindings := range hostConfig.PortBindings {
+		for _, binding := range bindings {
+			mapping := libpodPort{
+				HostIP:        binding.HostIP,
+				ContainerPort: uint16(port.Int()),
+				Protocol:      port.Proto(),
+			}
+			if binding.HostPort != "" {
+				hostPort, err := strconv.ParseUint(binding.HostPort, 10, 16)
+				if err != nil {
+					return nil, fmt.Errorf("invalid host port '%s': %w", binding.HostPort, err)
+				}
+				mapping.HostPort = uint16(hostPort)
+			}
+			spec.PortMappings = append(spec.PortMappings, mapping)
+		}
+	}
+
+	for _, bind := range hostConfig.Binds {
+		parts := strings.SplitN(bind, ":", 3)
+		if len(parts) == 1 && filepath.IsAbs(parts[0]) {
+			// An anonymous volume, which libpod creates for a volume without
+			// a name
+			spec.Volumes = append(spec.Volumes, libpodVolume{Dest: parts[0]})
+			continue
+		} else if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid bind '%s'", bind)
+		}
+		var options []string
+		if len(parts) == 3 {
+			options = strings.Split(parts[2], ",")
+		}
+		if filepath.IsAbs(parts[0]) {
+			spec.Mounts = append(spec.Mounts, libpodMount{Type: "bind", Source: parts[0], Destination: parts[1], Options: options})
+		} else {
+			spec.Volumes = append(spec.Volumes, libpodVolume{Name: parts[0], Dest: parts[1], Options: options})
+		}
+	}
+
+	for path, options := range hostConfig.Tmpfs {
+		m := libpodMount{Type: "tmpfs", Source: "tmpfs", Destination: path}
+		if options != "" {
+			m.Options = strings.Split(options, ",")
+		}
+		spec.Mounts = append(spec.Mounts, m)
+	}
+
+	for _, device := range hostConfig.Devices {
+		path := device.PathOnHost + ":" + device.PathInContainer + ":" + device.CgroupPermissions
+		spec.Devices = append(spec.Devices, libpodDevice{Path: path})
+	}
+
+	for _, rule := range hostConfig.DeviceCgroupRules {
+		r, err := libpodDeviceRuleFor(rule)
+		if err != nil {
+			return nil, err
+		}
+		spec.DeviceCgroupRules = append(spec.DeviceCgroupRules, r)
+	}
+
+	if hostConfig.ShmSize != 0 {
+		size := hostConfig.ShmSize
+		spec.ShmSize = &size
+	}
+
+	for _, ulimit := range hostConfig.Ulimits {
+		spec.Rlimits = append(spec.Rlimits, libpodRlimit{
+			Type: "RLIMIT_" + strings.ToUpper(ulimit.Name),
+			Hard: uint64(ulimit.Hard),
+			Soft: uint64(ulimit.Soft),
+		})
+	}
+
+	spec.Resources = libpodResourcesFor(hostConfig.Resources)
+
+	switch mode := string(hostConfig.NetworkMode); {
+	case mode == "" || mode == "default":
+	case mode == "host" || mode == "none" || mode == "bridge" || mode == "private":
+		spec.NetNS = &libpodNamespace{Mode: mode}
+	case strings.HasPrefix(mode, "container:"):
+		spec.NetNS = &libpodNamespace{Mode: "container", Value: strings.TrimPrefix(mode, "container:")}
+	default:
+		spec.NetNS = &libpodNamespace{Mode: "bridge"}
+		spec.Networks = map[string]struct{}{mode: {}}
+	}
+
+	if mode := string(hostConfig.UsernsMode); mode != "" {
+		spec.UserNS = &libpodNamespace{Mode: mode}
+	}
+
+	for _, opt := range hostConfig.SecurityOpt {
+		key, value, _ := strings.Cut(opt, "=")
+		switch key {
+		case "no-new-privileges":
+			spec.NoNewPrivileges = value == "" || value == "true"
+		case "apparmor":
+			spec.ApparmorProfile = value
+		case "label":
+			spec.SelinuxOpts = append(spec.SelinuxOpts, value)
+		case "systempaths":
+			if value == "unconfined" {
+				spec.Unmask = []string{"ALL"}
+			}
+		case "seccomp":
+			path, err := libpodSeccompProfile(value)
+			if err != nil {
+				return nil, err
+			}
+			spec.SeccompProfilePath = path
+		default:
+			return nil, fmt.Errorf("unsupported security-opt '%s'", opt)
+		}
+	}
+
+	return spec, nil
+}
+
+func libpodDeviceRuleFor(rule string) (libpodDeviceRule, error) {
+	r := libpodDeviceRule{Allow: true}
+
+	fields := strings.Fields(rule)
+	if len(fields) != 3 {
+		return r, fmt.Errorf("invalid device cgroup rule '%s'", rule)
+	}
+	major, minor, _ := strings.Cut(fields[1], ":")
+
+	r.Type = fields[0]
+	r.Access = fields[2]
+	for _, n := range []struct {
+		value string
+		field **int64
+	}{{major, &r.Major}, {minor, &r.Minor}} {
+		if n.value == "*" {
+			continue
+		}
+		number, err := strconv.ParseInt(n.value, 10, 64)
+		if err != nil {
+			return r, fmt.Errorf("invalid device cgroup rule '%s': %w", rule, err)
+		}
+		*n.field = &number
+	}
+
+	return r, nil
+}
+
+func libpodResourcesFor(resources container.Resources) *libpodResources {
+	r := &libpodResources{}
+	used := false
+
+	if resources.Memory != 0 || resources.MemorySwap != 0 {
+		r.Memory = &struct {
+			Limit *int64 `json:"limit,omitempty"`
+			Swap  *int64 `json:"swap,omitempty"`
+		}{}
+		if resources.Memory != 0 {
+			r.Memory.Limit = &resources.Memory
+		}
+		if resources.MemorySwap != 0 {
+			r.Memory.Swap = &resources.MemorySwap
+		}
+		used = true
+	}
+
+	if resources.NanoCPUs != 0 || resources.CpusetCpus != "" {
+		r.CPU = &struct {
+			Quota  *int64  `json:"quota,omitempty"`
+			Period *uint64 `json:"period,omitempty"`
+			Cpus   string  `json:"cpus,omitempty"`
+		}{Cpus: resources.CpusetCpus}
+		if resources.NanoCPUs != 0 {
+			// The same conversion as docker run --cpus
+			period := uint64(100000)
+			quota := resources.NanoCPUs * int64(period) / 1e9
+			r.CPU.Period = &period
+			r.CPU.Quota = &quota
+		}
+		used = true
+	}
+
+	if resources.PidsLimit != nil {
+		r.Pids = &struct {
+			Limit int64 `json:"limit"`
+		}{Limit: *resources.PidsLimit}
+		used = true
+	}
+
+	if !used {
+		return nil
+	}
+	return r
+}
+
+// libpodSeccompProfile returns the seccomp_profile_path for a Docker seccomp
+// security option. Docker takes the profile itself, but libpod takes a path,
+// so the profile is saved to the user's cache directory. The API is only used
+// over a local socket, so Podman can read it from there.
+func libpodSeccompProfile(value string) (string, error) {
+	switch value {
+	case "builtin":
+		return "", nil
+	case "unconfined":
+		return value, nil
+	}
+
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, "wharfrat", "seccomp")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+	if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+		return "", fmt.Errorf("failed to save seccomp profile: %w", err)
+	}
+
+	return path, nil
+}
+
+// ContainerInspect inspects a container using the libpod API, returning the
+// parts of the Docker inspect response that wharfrat uses.
+func (p *podmanRuntime) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	info := libpodContainer{}
+	if err := p.get(ctx, "/containers/"+url.PathEscape(containerID)+"/json", &info); err != nil {
+		return container.InspectResponse{}, err
+	}
+
+	status := container.ContainerState(info.State.Status)
+	if mapped, found := libpodStates[info.State.Status]; found {
+		status = mapped
+	}
+
+	// Older versions of Podman give the entrypoint as a string
+	var entrypoint []string
+	if err := json.Unmarshal(info.Config.Entrypoint, &entrypoint); err != nil {
+		var str string
+		if json.Unmarshal(info.Config.Entrypoint, &str) == nil && str != "" {
+			entrypoint = strings.Fields(str)
+		}
+	}
+
+	resp := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:      info.ID,
+			Created: info.Created,
+			Path:    info.Path,
+			Args:    info.Args,
+			State: &container.State{
+				Status:     status,
+				Running:    info.State.Running,
+				Paused:     info.State.Paused,
+				Restarting: info.State.Restarting,
+				OOMKilled:  info.State.OOMKilled,
+				Dead:       info.State.Dead,
+				Pid:        info.State.Pid,
+				ExitCode:   info.State.ExitCode,
+				Error:      info.State.Error,
+				StartedAt:  info.State.StartedAt,
+				FinishedAt: info.State.FinishedAt,
+			},
+			Image: info.Image,
+			Name:  "/" + strings.TrimPrefix(info.Name, "/"),
+			HostConfig: &container.HostConfig{
+				Binds:          info.HostConfig.Binds,
+				CapAdd:         info.HostConfig.CapAdd,
+				CapDrop:        info.HostConfig.CapDrop,
+				NetworkMode:    container.NetworkMode(info.HostConfig.NetworkMode),
+				Privileged:     info.HostConfig.Privileged,
+				ReadonlyRootfs: info.HostConfig.ReadonlyRootfs,
+				SecurityOpt:    info.HostConfig.SecurityOpt,
+				ShmSize:        info.HostConfig.ShmSize,
+				Tmpfs:          info.HostConfig.Tmpfs,
+				UsernsMode:     container.UsernsMode(info.HostConfig.UsernsMode),
+			},
+		},
+		Config: &container.Config{
+			Hostname:   info.Config.Hostname,
+			User:       info.Config.User,
+			Env:        info.Config.Env,
+			Cmd:        info.Config.Cmd,
+			Image:      info.ImageName,
+			WorkingDir: info.Config.WorkingDir,
+			Entrypoint: entrypoint,
+			Labels:     info.Config.Labels,
+		},
+		NetworkSettings: &container.NetworkSettings{
+			Networks: map[string]*network.EndpointSettings{},
+		},
+	}
+
+	for _, m := range info.Mounts {
+		resp.Mounts = append(resp.Mounts, container.MountPoint{
+			Type:        mount.Type(m.Type),
+			Name:        m.Name,
+			Source:      m.Source,
+			Destination: m.Destination,
+			Driver:      m.Driver,
+			Mode:        m.Mode,
+			RW:          m.RW,
+			Propagation: mount.Propagation(m.Propagation),
+		})
+	}
+
+	for name, n := range info.NetworkSettings.Networks {
+		resp.NetworkSettings.Networks[name] = &network.EndpointSettings{
+			NetworkID:         n.NetworkID,
+			EndpointID:        n.EndpointID,
+			Gateway:           n.Gateway,
+			IPAddress:         n.IPAddress,
+			IPPrefixLen:       n.IPPrefixLen,
+			GlobalIPv6Address: n.GlobalIPv6Address,
+			MacAddress:        n.MacAddress,
+		}
+	}
+
+	return resp, nil
+}
+
+// ContainerExecCreate creates an exec session using the libpod API, which
+// takes the same options as Docker.
+func (p *podmanRuntime) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	resp := container.ExecCreateResponse{}
+	if err := p.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/exec", nil, options, &resp); err != nil {
+		return container.ExecCreateResponse{}, err
+	}
+	return resp, nil
+}
+
+// ContainerExecAttach starts an exec session using the libpod API, and returns
+// the connection carrying its streams, which are multiplexed in the same way
+// as Docker's unless there is a tty.
+func (p *podmanRuntime) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	body := struct {
+		Detach bool `json:"Detach"`
+		Tty    bool `json:"Tty"`
+		Height uint `json:"h,omitempty"`
+		Width  uint `json:"w,omitempty"`
+	}{Detach: config.Detach, Tty: config.Tty}
+	if config.ConsoleSize != nil {
+		body.Height, body.Width = config.ConsoleSize[0], config.ConsoleSize[1]
+	}
+
+	req, err := p.request(ctx, http.MethodPost, "/exec/"+url.PathEscape(execID)+"/start", nil, body)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", p.socket)
+	if err != nil {
+		return types.HijackedResponse{}, err
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+
+	if err := podmanError(resp); err != nil {
+		conn.Close()
+		return types.HijackedResponse{}, err
+	}
+
+	return types.HijackedResponse{Conn: conn, Reader: reader}, nil
+}
+
+// ContainerExecInspect inspects an exec session using the libpod API.
+func (p *podmanRuntime) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	info := struct {
+		ID          string `json:"ID"`
+		ContainerID string `json:"ContainerID"`
+		Running     bool   `json:"Running"`
+		ExitCode    int    `json:"ExitCode"`
+		Pid         int    `json:"Pid"`
+	}{}
+	if err := p.get(ctx, "/exec/"+url.PathEscape(execID)+"/json", &info); err != nil {
+		return container.ExecInspect{}, err
+	}
+
+	return container.ExecInspect{
+		ExecID:      info.ID,
+		ContainerID: info.ContainerID,
+		Running:     info.Running,
+		ExitCode:    info.ExitCode,
+		Pid:         info.Pid,
+	}, nil
+}
+
+// ContainerExecResize resizes the tty of an exec session using the libpod API.
+func (p *podmanRuntime) ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error {
+	query := url.Values{
+		"h": {strconv.FormatUint(uint64(options.Height), 10)},
+		"w": {strconv.FormatUint(uint64(options.Width), 10)},
+	}
+	return p.call(ctx, http.MethodPost, "/exec/"+url.PathEscape(execID)+"/resize", query, nil, nil)
+}
@@ -0,0 +1,153 @@
+package docker
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestLibpodSpecBinds(t *testing.T) {
+	hostConfig := &container.HostConfig{
+		Binds: []string{
+			"/host:/container",
+			"/ro:/ro:ro,z",
+			"cache:/cache",
+			"/anonymous",
+		},
+	}
+
+	spec, err := libpodSpecFor(&container.Config{}, hostConfig, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantMounts := []libpodMount{
+		{Type: "bind", Source: "/host", Destination: "/container"},
+		{Type: "bind", Source: "/ro", Destination: "/ro", Options: []string{"ro", "z"}},
+	}
+	if !reflect.DeepEqual(spec.Mounts, wantMounts) {
+		t.Errorf("mounts: got %+v, want %+v", spec.Mounts, wantMounts)
+	}
+
+	wantVolumes := []libpodVolume{
+		{Name: "cache", Dest: "/cache"},
+		{Dest: "/anonymous"},
+	}
+	if !reflect.DeepEqual(spec.Volumes, wantVolumes) {
+		t.Errorf("volumes: got %+v, want %+v", spec.Volumes, wantVolumes)
+	}
+
+	for _, bind := range []string{"relative", ""} {
+		hostConfig := &container.HostConfig{Binds: []string{bind}}
+		if _, err := libpodSpecFor(&container.Config{}, hostConfig, "name"); err == nil {
+			t.Errorf("bind %q: got no error", bind)
+		}
+	}
+}
+
+func TestLibpodSpecNetwork(t *testing.T) {
+	tests := []struct {
+		mode     string
+		netns    *libpodNamespace
+		networks map[string]struct{}
+	}{
+		{"", nil, nil},
+		{"default", nil, nil},
+		{"host", &libpodNamespace{Mode: "host"}, nil},
+		{"none", &libpodNamespace{Mode: "none"}, nil},
+		{"container:other", &libpodNamespace{Mode: "container", Value: "other"}, nil},
+		{"mynet", &libpodNamespace{Mode: "bridge"}, map[string]struct{}{"mynet": {}}},
+	}
+
+	for _, test := range tests {
+		hostConfig := &container.HostConfig{NetworkMode: container.NetworkMode(test.mode)}
+		spec, err := libpodSpecFor(&container.Config{}, hostConfig, "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(spec.NetNS, test.netns) {
+			t.Errorf("%q netns: got %+v, want %+v", test.mode, spec.NetNS, test.netns)
+		}
+		if !reflect.DeepEqual(spec.Networks, test.networks) {
+			t.Errorf("%q networks: got %v, want %v", test.mode, spec.Networks, test.networks)
+		}
+	}
+}
+
+func TestLibpodSpecConfig(t *testing.T) {
+	config := &container.Config{
+		Env:          []string{"A=1", "B=x=y"},
+		ExposedPorts: nat.PortSet{"8080/tcp": {}},
+	}
+	hostConfig := &container.HostConfig{
+		PortBindings: nat.PortMap{"8080/tcp": {{HostIP: "127.0.0.1", HostPort: "80"}}},
+		SecurityOpt:  []string{"no-new-privileges", "apparmor=unconfined", "systempaths=unconfined"},
+	}
+
+	spec, err := libpodSpecFor(config, hostConfig, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]string{"A": "1", "B": "x=y"}; !reflect.DeepEqual(spec.Env, want) {
+		t.Errorf("env: got %v, want %v", spec.Env, want)
+	}
+	if want := map[uint16]string{8080: "tcp"}; !reflect.DeepEqual(spec.Expose, want) {
+		t.Errorf("expose: got %v, want %v", spec.Expose, want)
+	}
+	if want := []libpodPort{{HostIP: "127.0.0.1", HostPort: 80, ContainerPort: 8080, Protocol: "tcp"}}; !reflect.DeepEqual(spec.PortMappings, want) {
+		t.Errorf("ports: got %+v, want %+v", spec.PortMappings, want)
+	}
+	if !spec.NoNewPrivileges || spec.ApparmorProfile != "unconfined" || !reflect.DeepEqual(spec.Unmask, []string{"ALL"}) {
+		t.Errorf("security: got no-new-privileges=%v apparmor=%q unmask=%v", spec.NoNewPrivileges, spec.ApparmorProfile, spec.Unmask)
+	}
+	if spec.Entrypoint == nil {
+		t.Errorf("entrypoint: got nil, want empty list to clear the image entrypoint")
+	}
+
+	hostConfig = &container.HostConfig{SecurityOpt: []string{"unknown=1"}}
+	if _, err := libpodSpecFor(&container.Config{}, hostConfig, "name"); err == nil {
+		t.Errorf("unknown security-opt: got no error")
+	}
+}
+
+func TestLibpodDeviceRule(t *testing.T) {
+	number := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		rule string
+		want libpodDeviceRule
+		err  bool
+	}{
+		{rule: "c 1:3 rwm", want: libpodDeviceRule{Allow: true, Type: "c", Major: number(1), Minor: number(3), Access: "rwm"}},
+		{rule: "b *:* r", want: libpodDeviceRule{Allow: true, Type: "b", Access: "r"}},
+		{rule: "c 189:* rw", want: libpodDeviceRule{Allow: true, Type: "c", Major: number(189), Access: "rw"}},
+		{rule: "c 1:3", err: true},
+		{rule: "c x:3 rwm", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := libpodDeviceRuleFor(test.rule)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: got no error", test.rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.rule, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.rule, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// libpodVersion is the version of the libpod API that is used.
+const libpodVersion = "v4.0.0"
+
+// podmanRuntime talks to the Podman API service. Containers are created,
+// inspected and exec'd into, and images pulled, using the libpod API (see
+// libpod.go). Podman serves the Docker API alongside it on the same socket, so
+// the remaining calls (e.g. volumes, builds and commits) go through the Docker
+// client.
+type podmanRuntime struct {
+	*client.Client
+	libpod   *http.Client
+	host     string
+	socket   string
+	rootless bool
+}
+
+// podmanInfo is the part of the libpod info response that wharfrat uses.
+type podmanInfo struct {
+	Host struct {
+		Security struct {
+			Rootless bool `json:"rootless"`
+		} `json:"security"`
+	} `json:"host"`
+	Version struct {
+		Version string `json:"Version"`
+	} `json:"version"`
+}
+
+// podmanReport is a message from the libpod image pull stream.
+type podmanReport struct {
+	Stream string `json:"stream"`
+	Error  string `json:"error"`
+	ID     string `json:"id"`
+}
+
+// podmanSocket returns the Podman API socket to use, from CONTAINER_HOST, the
+// podman-url local config setting, or the default socket, which is per-user
+// for rootless Podman.
+func podmanSocket(configured string) string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if configured != "" {
+		return configured
+	}
+	if os.Getuid() == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+}
+
+func newPodman(ctx context.Context, host string) (Runtime, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid podman URL '%s': %w", host, err)
+	}
+	if u.Scheme != "unix" {
+		return nil, fmt.Errorf("podman is only supported over a unix socket, not '%s'", host)
+	}
+
+	c, err := client.NewClientWithOpts(client.WithHost(host))
+	if err != nil {
+		return nil, err
+	}
+
+	negotiate(ctx, c)
+
+	dialer := &net.Dialer{}
+	p := &podmanRuntime{
+		Client: c,
+		host:   host,
+		socket: u.Path,
+		libpod: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", u.Path)
+				},
+			},
+		},
+	}
+
+	info := podmanInfo{}
+	if err := p.get(ctx, "/info", &info); err != nil {
+		return nil, fmt.Errorf("failed to get podman info: %w", err)
+	}
+
+	p.rootless = info.Host.Security.Rootless
+
+	log.Printf("PODMAN: version: %s, rootless: %v", info.Version.Version, p.rootless)
+
+	return p, nil
+}
+
+// request returns a request for the libpod API, with data encoded as the JSON
+// body if it isn't nil.
+func (p *podmanRuntime) request(ctx context.Context, method, path string, query url.Values, data interface{}) (*http.Request, error) {
+	u := "http://podman/" + libpodVersion + "/libpod" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if data != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(data); err != nil {
+			return nil, err
+		}
+		body = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	log.Printf("LIBPOD: %s %s", method, u)
+
+	return req, nil
+}
+
+// do makes a request to the libpod API.
+func (p *podmanRuntime) do(ctx context.Context, method, path string, query url.Values, header http.Header, data interface{}) (*http.Response, error) {
+	req, err := p.request(ctx, method, path, query, data)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := p.libpod.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := podmanError(resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// podmanError returns the error in a failed libpod response, closing the body.
+func podmanError(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+
+	defer resp.Body.Close()
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Message == "" {
+		body.Message = resp.Status
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errdefs.ErrNotFound, body.Message)
+	}
+	return fmt.Errorf("podman: %s", body.Message)
+}
+
+func (p *podmanRuntime) get(ctx context.Context, path string, data interface{}) error {
+	return p.call(ctx, http.MethodGet, path, nil, nil, data)
+}
+
+// call makes a request to the libpod API with a JSON body and response, either
+// of which may be nil.
+func (p *podmanRuntime) call(ctx context.Context, method, path string, query url.Values, body, data interface{}) error {
+	resp, err := p.do(ctx, method, path, query, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if data == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(data)
+}
+
+func (p *podmanRuntime) Name() string {
+	return config.RuntimePodman
+}
+
+func (p *podmanRuntime) Endpoint() string {
+	return p.host
+}
+
+// UsernsMode maps the user's ID on the host to the same ID in rootless
+// containers, so that files in mounted directories have the right owner.
+func (p *podmanRuntime) UsernsMode() container.UsernsMode {
+	if p.rootless {
+		return "keep-id"
+	}
+	return ""
+}
+
+// ImageCreate pulls an image using the libpod API, so that Podman's registry
+// config (e.g. short name aliases) is used. The libpod progress messages are
+// converted to Docker JSON messages.
+func (p *podmanRuntime) ImageCreate(ctx context.Context, parentReference string, options image.CreateOptions) (io.ReadCloser, error) {
+	query := url.Values{
+		"reference": {parentReference},
+		"policy":    {"always"},
+	}
+
+	if options.Platform != "" {
+		parts := strings.Split(options.Platform, "/")
+		query.Set("OS", parts[0])
+		if len(parts) > 1 {
+			query.Set("Arch", parts[1])
+		}
+		if len(parts) > 2 {
+			query.Set("Variant", parts[2])
+		}
+	}
+
+	header := http.Header{}
+	if options.RegistryAuth != "" {
+		header.Set("X-Registry-Auth", options.RegistryAuth)
+	}
+
+	resp, err := p.do(ctx, http.MethodPost, "/images/pull", query, header, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		dec := json.NewDecoder(resp.Body)
+		enc := json.NewEncoder(w)
+		for {
+			report := podmanReport{}
+			if err := dec.Decode(&report); err == io.EOF {
+				w.Close()
+				return
+			} else if err != nil {
+				w.CloseWithError(err)
+				return
+			}
+
+			msg := jsonmessage.JSONMessage{Stream: report.Stream}
+			if report.Error != "" {
+				msg.Error = &jsonmessage.JSONError{Message: report.Error}
+			} else if report.ID != "" && report.Stream == "" {
+				msg.Status = "Pulled " + report.ID
+			}
+
+			if err := enc.Encode(msg); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return r, nil
+}
@@ -0,0 +1,272 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/registry"
+	"github.com/moby/term"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// updateTimeout limits how long to wait for a registry when checking for a
+// newer image.
+const updateTimeout = 10 * time.Second
+
+// pullInterval is how often images are pulled with the daily pull policy.
+const pullInterval = 24 * time.Hour
+
+// registryAuth returns the saved credentials for the registry that the named
+// image comes from, or an empty string if there are none.
+func (c *Connection) registryAuth(name string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", err
+	}
+
+	repoInfo, err := registry.ParseRepositoryInfo(ref)
+	if err != nil {
+		return "", err
+	}
+
+	authName := repoInfo.Index.Name
+	if repoInfo.Index.Official {
+		info, err := c.Info()
+		if err != nil {
+			return "", err
+		}
+		authName = info.IndexServerAddress
+	}
+
+	log.Printf("REF: %v, REG: %v, Name: %s", ref, repoInfo, authName)
+
+	creds, err := LoadCredentials()
+	if err != nil {
+		log.Printf("Failed to load saved auth: %s", err)
+		return "", nil
+	}
+
+	auth, err := creds.Encoded(authName)
+	if err != nil {
+		log.Printf("Failed to get saved auth for %s: %s", authName, err)
+		return "", nil
+	}
+
+	return auth, nil
+}
+
+// pullImage pulls the named image for platform, or for linux on the daemon's
+// architecture if platform is empty.
+func (c *Connection) pullImage(name, platform string) error {
+	auth, err := c.registryAuth(name)
+	if err != nil {
+		return err
+	}
+
+	if platform == "" {
+		platform = "linux"
+	}
+
+	options := image.CreateOptions{
+		RegistryAuth: auth,
+		Platform:     platform,
+	}
+
+	resp, err := c.c.ImageCreate(c.ctx, name, options)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+
+	fd, term := term.GetFdInfo(os.Stderr)
+
+	log.Printf("PULL: image:%s fd:%d term:%v", name, fd, term)
+
+	if err := jsonmessage.DisplayJSONMessagesStream(resp, os.Stderr, fd, term, nil); err != nil {
+		return err
+	}
+
+	c.pulled[name] = true
+
+	if err := config.RecordPull(name); err != nil {
+		log.Printf("Failed to record pull of %s: %s", name, err)
+	}
+
+	return nil
+}
+
+// Pull pulls the image for the crate from its registry, using any credentials
+// saved by login.
+func (c *Connection) Pull(crate *config.Crate) error {
+	if crate.Build != nil {
+		return fmt.Errorf("image is built from %s", crate.BuildDir())
+	}
+
+	if err := c.pullImage(crate.Image, crate.Platform); err != nil {
+		return err
+	}
+
+	return crate.SetDefaults(c)
+}
+
+// ensurePulled pulls the image for the crate if the crate's pull policy says
+// that it should be pulled. If the pull fails, but there is a local copy of the
+// image, then the local copy is used.
+func (c *Connection) ensurePulled(crate *config.Crate) error {
+	if crate.Build != nil || c.pulled[crate.Image] {
+		return nil
+	}
+
+	local, err := c.GetImage(crate.Image)
+	if err != nil {
+		return err
+	}
+
+	// A local image for another architecture is no use, so treat it as
+	// missing
+	if platform, err := crate.ImagePlatform(); err != nil {
+		return err
+	} else if local != nil && crate.Platform != "" && local.Architecture != platform.Architecture {
+		log.Printf("LOCAL IMAGE: wanted %s, got %s", platform.Architecture, local.Architecture)
+		local = nil
+	}
+
+	policy := crate.ImagePullPolicy()
+	pull := false
+	switch policy {
+	case config.PullAlways:
+		pull = true
+	case config.PullMissing:
+		pull = local == nil
+	case config.PullNever:
+		if local == nil {
+			return fmt.Errorf("image %s not available locally, and pull-policy is never", crate.Image)
+		}
+	case config.PullDaily:
+		pull = local == nil || time.Since(config.LastPull(crate.Image)) > pullInterval
+	default:
+		return fmt.Errorf("invalid pull-policy: %s", policy)
+	}
+
+	log.Printf("PULL POLICY: %s, local: %v, pull: %v", policy, local != nil, pull)
+
+	if !pull {
+		return nil
+	}
+
+	if err := c.Pull(crate); err != nil {
+		if local == nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Failed to pull %s, using local image: %s\n", crate.Image, err)
+	}
+
+	return nil
+}
+
+// ImageUpdate reports whether the registry has a different image for name than
+// the local copy. Images that aren't available locally, or that are referenced
+// by digest, are never reported as updated.
+func (c *Connection) ImageUpdate(name string) (bool, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := ref.(reference.Canonical); ok {
+		return false, nil
+	}
+
+	local, err := c.GetImage(name)
+	if err != nil || local == nil {
+		return false, err
+	}
+
+	auth, err := c.registryAuth(name)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, updateTimeout)
+	defer cancel()
+
+	dist, err := c.c.DistributionInspect(ctx, name, auth)
+	if err != nil {
+		return false, err
+	}
+
+	latest, err := reference.WithDigest(reference.TrimNamed(ref), dist.Descriptor.Digest)
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("IMAGE DIGESTS (%s): local: %v, registry: %s", name, local.RepoDigests, latest)
+
+	for _, digest := range local.RepoDigests {
+		localRef, err := reference.ParseNormalizedNamed(digest)
+		if err != nil {
+			continue
+		}
+		if localRef.String() == latest.String() {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// ResolveDigest returns a reference to the named image that includes the
+// digest of the image in the registry, for pinning the image. If the registry
+// can't be reached, then the digest of the local copy of the image is used
+// instead.
+func (c *Connection) ResolveDigest(name string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ref.(reference.Canonical); ok {
+		return name, nil
+	}
+
+	auth, err := c.registryAuth(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, updateTimeout)
+	defer cancel()
+
+	dist, distErr := c.c.DistributionInspect(ctx, name, auth)
+	if distErr == nil {
+		pinned, err := reference.WithDigest(reference.TrimNamed(ref), dist.Descriptor.Digest)
+		if err != nil {
+			return "", err
+		}
+		return reference.FamiliarString(pinned), nil
+	}
+
+	log.Printf("Failed to get registry digest for %s: %s", name, distErr)
+
+	local, err := c.GetImage(name)
+	if err != nil || local == nil {
+		return "", distErr
+	}
+
+	for _, digest := range local.RepoDigests {
+		localRef, err := reference.ParseNormalizedNamed(digest)
+		if err != nil {
+			continue
+		}
+		if localRef.Name() == ref.Name() {
+			return reference.FamiliarString(localRef), nil
+		}
+	}
+
+	return "", distErr
+}
@@ -0,0 +1,81 @@
+package docker
+
+import (
+	"log"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"wharfr.at/wharfrat/lib/docker/label"
+)
+
+// setupRepository is the repository that images of set up read-only containers
+// are committed to.
+const setupRepository = "wharfrat-setup"
+
+// recreateReadOnly replaces the container id, which has been set up, with a
+// container with a read-only root filesystem. Docker won't copy files into a
+// read-only container, so the set up container is committed to an image that
+// the read-only container is then created from.
+func (c *Connection) recreateReadOnly(id string, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, name string) (string, error) {
+	info, err := c.c.ContainerInspect(c.ctx, id)
+	if err != nil {
+		return "", err
+	}
+
+	ref := setupRepository + ":" + name
+	commit, err := c.c.ContainerCommit(c.ctx, id, container.CommitOptions{
+		Reference: ref,
+		Comment:   "wharfrat setup for " + name,
+		Pause:     true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("COMMITTED: %s -> %s (%s)", id, ref, commit.ID)
+
+	if err := c.c.ContainerRemove(c.ctx, id, container.RemoveOptions{Force: true}); err != nil {
+		return "", err
+	}
+
+	readOnlyConfig := *config
+	readOnlyConfig.Image = commit.ID
+	readOnlyConfig.Labels = map[string]string{}
+	for key, value := range config.Labels {
+		readOnlyConfig.Labels[key] = value
+	}
+	readOnlyConfig.Labels[label.BaseImage] = info.Image
+	readOnlyConfig.Labels[label.SetupImage] = ref
+
+	readOnlyHostConfig := *hostConfig
+	readOnlyHostConfig.ReadonlyRootfs = true
+
+	create, err := c.c.ContainerCreate(c.ctx, &readOnlyConfig, &readOnlyHostConfig, networkingConfig, platform, name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.c.ContainerStart(c.ctx, create.ID, container.StartOptions{}); err != nil {
+		return "", err
+	}
+
+	log.Printf("READ-ONLY STARTED: %s", create.ID)
+
+	return create.ID, nil
+}
+
+// removeSetupImage removes the image that a read-only container was created
+// from, once the container has been removed.
+func (c *Connection) removeSetupImage(labels map[string]string) {
+	ref := labels[label.SetupImage]
+	if ref == "" {
+		return
+	}
+	if _, err := c.c.ImageRemove(c.ctx, ref, image.RemoveOptions{PruneChildren: true}); err != nil {
+		log.Printf("Failed to remove setup image %s: %s", ref, err)
+	}
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"wharfr.at/wharfrat/lib/config"
+)
+
+// Runtime is the container runtime API used by Connection. The calls match
+// the Docker client, which other runtimes translate to their own API where
+// needed.
+type Runtime interface {
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerUnpause(ctx context.Context, containerID string) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerCommit(ctx context.Context, containerID string, options container.CommitOptions) (container.CommitResponse, error)
+
+	ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error
+
+	CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error
+
+	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
+	ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error)
+	ImageCreate(ctx context.Context, parentReference string, options image.CreateOptions) (io.ReadCloser, error)
+	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+	ImageBuild(ctx context.Context, buildContext io.Reader, options build.ImageBuildOptions) (build.ImageBuildResponse, error)
+	DistributionInspect(ctx context.Context, imageRef, encodedRegistryAuth string) (registry.DistributionInspect, error)
+	RegistryLogin(ctx context.Context, auth registry.AuthConfig) (registry.AuthenticateOKBody, error)
+
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+
+	Info(ctx context.Context) (system.Info, error)
+	ClientVersion() string
+	Close() error
+
+	// Name returns the name of the runtime (e.g. docker).
+	Name() string
+
+	// Endpoint describes where the runtime is being reached.
+	Endpoint() string
+
+	// UsernsMode returns the user namespace mode that containers should be
+	// created with, or an empty string for the runtime's default.
+	UsernsMode() container.UsernsMode
+}
+
+// newRuntime returns the runtime selected by the local config.
+func newRuntime(ctx context.Context, local *config.LocalConfig) (Runtime, error) {
+	switch local.Runtime {
+	case "", config.RuntimeDocker:
+		endpoint, err := ResolveEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		return newDocker(ctx, endpoint)
+	case config.RuntimePodman:
+		if Context != "" {
+			return nil, fmt.Errorf("--context is not supported with podman")
+		}
+		return newPodman(ctx, podmanSocket(local.PodmanURL))
+	}
+	return nil, fmt.Errorf("unknown runtime: %s", local.Runtime)
+}
+
+// dockerRuntime talks to a Docker daemon.
+type dockerRuntime struct {
+	*client.Client
+	endpoint *Endpoint
+}
+
+func newDocker(ctx context.Context, endpoint *Endpoint) (Runtime, error) {
+	log.Printf("ENDPOINT: %#v", endpoint)
+
+	opts, err := endpoint.clientOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	negotiate(ctx, c)
+
+	return dockerRuntime{c, endpoint}, nil
+}
+
+// negotiate picks the API version to use with the daemon.
+func negotiate(ctx context.Context, c *client.Client) {
+	before := c.ClientVersion()
+	c.NegotiateAPIVersion(ctx)
+	after := c.ClientVersion()
+
+	log.Printf("API: before: %s, after: %s", before, after)
+}
+
+func (dockerRuntime) Name() string {
+	return config.RuntimeDocker
+}
+
+func (d dockerRuntime) Endpoint() string {
+	return d.endpoint.String()
+}
+
+func (dockerRuntime) UsernsMode() container.UsernsMode {
+	return ""
+}
@@ -38,6 +38,16 @@ func (c *Connection) setupUser(id string, crate *config.Crate, usr *user.User, g
 		cmd = append(cmd, "--mkhome")
 	}
 
+	for _, path := range crate.Caches {
+		cmd = append(cmd, "--chown", path)
+	}
+
+	// With keep-id the runtime has already added the user to the container,
+	// so it needs to be updated rather than created
+	if c.c.UsernsMode() == "keep-id" {
+		cmd = append(cmd, "--existing")
+	}
+
 	buf := &bytes.Buffer{}
 
 	exitCode, err := c.run(id, cmd, nil, nil, buf, buf)
@@ -200,5 +210,13 @@ func (c *Connection) setup(id string, crate *config.Crate) error {
 		}
 	}
 
+	if err := c.setupGUI(id, crate, usr, group); err != nil {
+		return err
+	}
+
+	if err := c.setupGPGAgent(id, crate, usr, group); err != nil {
+		return err
+	}
+
 	return nil
 }
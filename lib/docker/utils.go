@@ -4,17 +4,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
 
-	"wharfr.at/wharfrat/lib/config"
-
-	"github.com/distribution/reference"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/image"
-	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/stdcopy"
-	"github.com/docker/docker/registry"
-	"github.com/moby/term"
 )
 
 func (c *Connection) run(id string, cmd []string, env map[string]string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
@@ -90,49 +82,3 @@ func (c *Connection) run(id string, cmd []string, env map[string]string, stdin i
 
 	return inspect.ExitCode, nil
 }
-
-func (c *Connection) pullImage(name string) error {
-	ref, err := reference.ParseNormalizedNamed(name)
-	if err != nil {
-		return err
-	}
-
-	repoInfo, err := registry.ParseRepositoryInfo(ref)
-	if err != nil {
-		return err
-	}
-
-	authName := repoInfo.Index.Name
-	if repoInfo.Index.Official {
-		info, err := c.Info()
-		if err != nil {
-			return err
-		}
-		address := info.IndexServerAddress
-		authName = registry.ConvertToHostname(address)
-	}
-
-	log.Printf("REF: %v, REG: %v, Name: %s", ref, repoInfo, authName)
-
-	auth, err := config.LoadAuth()
-	if err != nil {
-		log.Printf("Failed to load saved auth: %s", err)
-	}
-
-	options := image.CreateOptions{
-		RegistryAuth: auth[authName],
-		Platform:     "linux",
-	}
-
-	resp, err := c.c.ImageCreate(c.ctx, name, options)
-	if err != nil {
-		return err
-	}
-	defer resp.Close()
-
-	fd, term := term.GetFdInfo(os.Stderr)
-
-	log.Printf("PULL: image:%s fd:%d term:%v", name, fd, term)
-
-	return jsonmessage.DisplayJSONMessagesStream(resp, os.Stderr, fd, term, nil)
-}
@@ -25,7 +25,7 @@ func (e *ExecCfg) getCrate(ls config.LabelSource) (*config.Crate, error) {
 	path := e.Project
 	base := filepath.Dir(e.Path)
 	if path == "" {
-		return config.GetCrate(base, e.Crate, ls)
+		return config.GetTrustedCrate(base, e.Crate, ls)
 	}
 	if !filepath.IsAbs(path) {
 		path = filepath.Join(base, path)
@@ -0,0 +1,11 @@
+//go:build multiarch
+
+package self
+
+import "embed"
+
+// payloads holds linux binaries for other architectures, as
+// dist/linux/<GOARCH>/wharfrat.
+//
+//go:embed dist/linux
+var payloads embed.FS
@@ -0,0 +1,9 @@
+//go:build !multiarch
+
+package self
+
+import "embed"
+
+// payloads is empty without the multiarch tag, so only the native
+// architecture is available.
+var payloads embed.FS
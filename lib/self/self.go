@@ -0,0 +1,44 @@
+package self
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const payloadDir = "dist/linux"
+
+// Architectures returns the architectures that a linux binary is available
+// for.
+func Architectures() []string {
+	arches := []string{runtime.GOARCH}
+	entries, err := fs.ReadDir(payloads, payloadDir)
+	if err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() && entry.Name() != runtime.GOARCH {
+				arches = append(arches, entry.Name())
+			}
+		}
+	}
+	sort.Strings(arches)
+	return arches
+}
+
+// GetLinux returns a linux wharfrat binary for arch. The native binary is
+// used for the architecture that this binary was built for, otherwise one of
+// the binaries embedded by a multiarch build is used.
+func GetLinux(arch string) ([]byte, error) {
+	if arch == runtime.GOARCH {
+		return getNative()
+	}
+
+	data, err := payloads.ReadFile(path.Join(payloadDir, arch, "wharfrat"))
+	if err != nil {
+		return nil, fmt.Errorf("no wr-init binary available for linux/%s (available: %s)", arch, strings.Join(Architectures(), ", "))
+	}
+
+	return data, nil
+}
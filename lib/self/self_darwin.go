@@ -1,6 +1,8 @@
 package self
 
-func GetLinux() ([]byte, error) {
+// getNative returns the embedded linux binary, which is built for the same
+// architecture as this binary.
+func getNative() ([]byte, error) {
 	return linuxData, nil
 }
 
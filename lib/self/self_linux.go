@@ -6,7 +6,9 @@ import (
 	"os"
 )
 
-func GetLinux() ([]byte, error) {
+// getNative returns this binary, for copying into containers of the same
+// architecture.
+func getNative() ([]byte, error) {
 	self, err := os.Open("/proc/self/exe")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get self: %w", err)
@@ -3,7 +3,9 @@ package vc
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -24,13 +26,18 @@ func Branch(path string) (string, error) {
 }
 
 func BranchedFile(path, branch string) (string, error) {
-	dirPath := filepath.Dir(path)
-	fileName := filepath.Base(path)
-	log.Printf("VC BRANCHED FILE: %s", path)
+	return BranchedFileIn(filepath.Dir(path), filepath.Base(path), branch)
+}
+
+// BranchedFileIn returns the content of the file at name on the given branch,
+// where name is relative to dir. Unlike BranchedFile, the directory holding
+// the file does not need to exist in the working tree.
+func BranchedFileIn(dir, name, branch string) (string, error) {
+	log.Printf("VC BRANCHED FILE: %s %s", dir, name)
 	buf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
-	cmd := exec.Command("git", "show", branch+":./"+fileName)
-	cmd.Dir = dirPath
+	cmd := exec.Command("git", "show", branch+":./"+filepath.ToSlash(name))
+	cmd.Dir = dir
 	cmd.Stdout = buf
 	cmd.Stderr = errBuf
 	if err := cmd.Run(); err != nil {
@@ -40,13 +47,18 @@ func BranchedFile(path, branch string) (string, error) {
 }
 
 func KnownFile(path, branch string) bool {
-	dirPath := filepath.Dir(path)
-	fileName := filepath.Base(path)
-	log.Printf("VC BRANCHED FILE: %s", path)
+	return KnownFileIn(filepath.Dir(path), filepath.Base(path), branch)
+}
+
+// KnownFileIn returns true if name is a file on the given branch, where name
+// is relative to dir. Like BranchedFileIn, the directory holding the file does
+// not need to exist in the working tree.
+func KnownFileIn(dir, name, branch string) bool {
+	log.Printf("VC BRANCHED FILE: %s %s", dir, name)
 	buf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
-	cmd := exec.Command("git", "cat-file", "-t", branch+":./"+fileName)
-	cmd.Dir = dirPath
+	cmd := exec.Command("git", "cat-file", "-t", branch+":./"+filepath.ToSlash(name))
+	cmd.Dir = dir
 	cmd.Stdout = buf
 	cmd.Stderr = errBuf
 	if err := cmd.Run(); err != nil {
@@ -54,3 +66,82 @@ func KnownFile(path, branch string) bool {
 	}
 	return strings.TrimSpace(buf.String()) == "blob"
 }
+
+// BranchedDir returns the names of the entries in the directory at name on the
+// given branch, where name is relative to dir. If the directory does not exist
+// on the branch, then an error wrapping os.ErrNotExist is returned.
+func BranchedDir(dir, name, branch string) ([]string, error) {
+	log.Printf("VC BRANCHED DIR: %s %s", dir, name)
+	ref := branch + ":./" + filepath.ToSlash(name)
+	typeBuf := &bytes.Buffer{}
+	cmd := exec.Command("git", "cat-file", "-t", ref)
+	cmd.Dir = dir
+	cmd.Stdout = typeBuf
+	if err := cmd.Run(); err != nil || strings.TrimSpace(typeBuf.String()) != "tree" {
+		return nil, fmt.Errorf("%s on %s: %w", name, branch, os.ErrNotExist)
+	}
+	buf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	cmd = exec.Command("git", "ls-tree", "--name-only", ref)
+	cmd.Dir = dir
+	cmd.Stdout = buf
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git failed (%s): %s", err, errBuf)
+	}
+	names := []string{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	return names, nil
+}
+
+// TreeEntry is a file in a version control tree.
+type TreeEntry struct {
+	Mode   string
+	Object string
+	Path   string
+}
+
+// BranchedTree returns the files under the directory at name on the given
+// branch, where name is relative to dir. Paths are relative to name.
+func BranchedTree(dir, name, branch string) ([]TreeEntry, error) {
+	log.Printf("VC BRANCHED TREE: %s %s", dir, name)
+	buf := &bytes.Buffer{}
+	errBuf := &bytes.Buffer{}
+	cmd := exec.Command("git", "ls-tree", "-r", "-z", branch+":./"+filepath.ToSlash(name))
+	cmd.Dir = dir
+	cmd.Stdout = buf
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git failed (%s): %s", err, errBuf)
+	}
+	entries := []TreeEntry{}
+	for _, record := range strings.Split(buf.String(), "\x00") {
+		// Records are "MODE TYPE OBJECT\tPATH"
+		info, path, found := strings.Cut(record, "\t")
+		fields := strings.Fields(info)
+		if !found || len(fields) != 3 {
+			continue
+		}
+		entries = append(entries, TreeEntry{Mode: fields[0], Object: fields[2], Path: path})
+	}
+	return entries, nil
+}
+
+// BranchedArchive writes a tar archive of the directory at name on the given
+// branch to w, where name is relative to dir.
+func BranchedArchive(dir, name, branch string, w io.Writer) error {
+	log.Printf("VC BRANCHED ARCHIVE: %s %s", dir, name)
+	errBuf := &bytes.Buffer{}
+	cmd := exec.Command("git", "archive", "--format=tar", branch+":./"+filepath.ToSlash(name))
+	cmd.Dir = dir
+	cmd.Stdout = w
+	cmd.Stderr = errBuf
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git failed (%s): %s", err, errBuf)
+	}
+	return nil
+}
@@ -57,7 +57,7 @@ func newState(path, project string, crates []string, c *docker.Connection) (*sta
 		Binaries: map[string][]binary{},
 	}
 	for _, name := range crates {
-		crate, err := config.GetCrate(".", name, c)
+		crate, err := config.GetTrustedCrate(".", name, c)
 		if err == config.CrateNotFound {
 			return nil, fmt.Errorf("unknown crate: %s", name)
 		} else if err != nil {